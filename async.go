@@ -0,0 +1,158 @@
+package log
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var errAsyncWriterClosed = errors.New("log: async writer closed")
+
+// AsyncWriter is a writer which writes entries to the underlying writer by its
+// own goroutine, it's useful for slow sinks such as network writers while the
+// provider remains synchronous, e.g.
+//
+//	w := log.NewAsyncWriter(networkWriter, 4096)
+//	log.Start(log.WithSync(true), log.WithOutput(os.Stderr), log.WithWriters(w))
+//
+// Entries are written to the underlying writer in the order of Write calls.
+// Entries are dropped if the queue is full, see Dropped. Close waits until
+// all queued entries are written, so fatal entries are not lost as the
+// provider closes writers before exiting.
+type AsyncWriter struct {
+	writer  Writer
+	entries chan Entry
+	done    chan struct{}
+	dropped int64
+
+	mu     sync.RWMutex // guards closing entries against Write
+	closed bool
+
+	errMu   sync.Mutex
+	lastErr error // error of the last write to the underlying writer
+}
+
+// NewAsyncWriter creates an async writer for w with a queue which holds at
+// most queueSize entries
+func NewAsyncWriter(w Writer, queueSize int) *AsyncWriter {
+	if w == nil {
+		panic("log: NewAsyncWriter with a nil writer")
+	}
+	if queueSize <= 0 {
+		panic("log: async writer queue size must be positive")
+	}
+	aw := &AsyncWriter{
+		writer:  w,
+		entries: make(chan Entry, queueSize),
+		done:    make(chan struct{}),
+	}
+	go aw.run()
+	return aw
+}
+
+func (w *AsyncWriter) run() {
+	defer close(w.done)
+	var batch []Entry
+	for e := range w.entries {
+		batch = append(batch[:0], e)
+	drain:
+		for len(batch) < cap(w.entries) {
+			select {
+			case e, ok := <-w.entries:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, e)
+			default:
+				break drain
+			}
+		}
+		var err error
+		if len(batch) == 1 {
+			err = write(w.writer, batch[0].Level, batch[0].Data, batch[0].HeaderLen)
+		} else {
+			err = writeBatch(w.writer, batch)
+		}
+		w.errMu.Lock()
+		w.lastErr = err
+		w.errMu.Unlock()
+		for i := range batch {
+			batch[i] = Entry{}
+		}
+	}
+}
+
+// Write implements Writer Write method, data is copied and queued. It never
+// blocks, the entry is dropped if the queue is full.
+func (w *AsyncWriter) Write(level Level, data []byte, headerLen int) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return errAsyncWriterClosed
+	}
+	e := Entry{
+		Level:     level,
+		Data:      append([]byte(nil), data...),
+		HeaderLen: headerLen,
+	}
+	select {
+	case w.entries <- e:
+	default:
+		atomic.AddInt64(&w.dropped, 1)
+	}
+	return nil
+}
+
+// Dropped returns the number of entries dropped since the queue is full
+func (w *AsyncWriter) Dropped() int64 {
+	return atomic.LoadInt64(&w.dropped)
+}
+
+// Healthy implements HealthChecker Healthy method. It returns an error if the
+// writer is closed, otherwise the health of the underlying writer if it
+// implements HealthChecker, or the error of the last write.
+func (w *AsyncWriter) Healthy() error {
+	w.mu.RLock()
+	closed := w.closed
+	w.mu.RUnlock()
+	if closed {
+		return errAsyncWriterClosed
+	}
+	if h, ok := w.writer.(HealthChecker); ok {
+		return h.Healthy()
+	}
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	return w.lastErr
+}
+
+// Close implements Writer Close method, it waits until queued entries are
+// written and closes the underlying writer
+func (w *AsyncWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.entries)
+	w.mu.Unlock()
+	<-w.done
+	return w.writer.Close()
+}
+
+// Reopen implements Reopener Reopen method if the underlying writer implements it
+func (w *AsyncWriter) Reopen() error {
+	if r, ok := w.writer.(Reopener); ok {
+		return r.Reopen()
+	}
+	return nil
+}
+
+// Rotate implements Rotatable Rotate method if the underlying writer implements it
+func (w *AsyncWriter) Rotate() error {
+	if r, ok := w.writer.(Rotatable); ok {
+		return r.Rotate()
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package log_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gopherd/log"
+)
+
+// gatedWriter blocks the first Write until gate closed
+type gatedWriter struct {
+	testingLogWriter
+	once    sync.Once
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func (w *gatedWriter) Write(level log.Level, data []byte, headerLen int) error {
+	w.once.Do(func() {
+		close(w.started)
+		<-w.gate
+	})
+	return w.testingLogWriter.Write(level, data, headerLen)
+}
+
+func TestAsyncWriter(t *testing.T) {
+	inner := &gatedWriter{started: make(chan struct{}), gate: make(chan struct{})}
+	w := log.NewAsyncWriter(inner, 1)
+	w.Write(log.LevelInfo, []byte("first\n"), 0)
+	<-inner.started
+	w.Write(log.LevelInfo, []byte("second\n"), 0)
+	w.Write(log.LevelInfo, []byte("dropped\n"), 0)
+	if got := w.Dropped(); got != 1 {
+		t.Errorf("want 1 dropped, but got %d", got)
+	}
+	close(inner.gate)
+	if err := w.Close(); err != nil {
+		t.Errorf("close error: %v", err)
+	}
+	if want := "[INFO] first\n[INFO] second\n"; inner.buf.String() != want {
+		t.Errorf("want %q, but got %q", want, inner.buf.String())
+	}
+	if err := w.Write(log.LevelInfo, []byte("closed\n"), 0); err == nil {
+		t.Error("want an error after closed")
+	}
+
+	// entries are flushed by Shutdown of a synchronous logger
+	writer := new(testingLogWriter)
+	logger := log.NewLogger("")
+	logger.Start(log.WithWriters(log.NewAsyncWriter(writer, 64)), log.WithSync(true))
+	for i := 0; i < 10; i++ {
+		logger.Info().Int("i", i).Print("async")
+	}
+	logger.Shutdown()
+	if got := strings.Count(writer.buf.String(), "} async\n"); got != 10 {
+		t.Errorf("want 10 entries, but got %d", got)
+	}
+}
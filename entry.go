@@ -2,19 +2,31 @@ package log
 
 import (
 	"bytes"
+	"sync"
+	"time"
 )
 
 type entry struct {
 	buf    bytes.Buffer
 	tmp    [64]byte
-	next   *entry
 	level  Level
+	flags  int
 	header int
+	time   time.Time // when the header formatted or the context created, or zero if unknown
+	record Record    // filled only for structured writers
+}
+
+var entryPool = sync.Pool{
+	New: func() interface{} {
+		return new(entry)
+	},
 }
 
 func (e *entry) reset() {
 	e.buf.Reset()
 	e.header = 0
+	e.time = time.Time{}
+	e.record = Record{}
 }
 
 const digits = "0123456789"
@@ -91,6 +103,14 @@ func (q *queue) push(e *entry) int {
 	return len(q.in)
 }
 
+func (q *queue) popFront() *entry {
+	e := q.in[0]
+	copy(q.in, q.in[1:])
+	q.in[len(q.in)-1] = nil
+	q.in = q.in[:len(q.in)-1]
+	return e
+}
+
 func (q *queue) popAll() []*entry {
 	q.in, q.out = q.out, q.in
 	q.in = q.in[:0]
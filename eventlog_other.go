@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package log
+
+import (
+	"errors"
+	"runtime"
+)
+
+func openEventLog(source string) (Writer, error) {
+	return nil, errors.New("log: eventlog is unsupported on " + runtime.GOOS)
+}
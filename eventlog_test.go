@@ -0,0 +1,17 @@
+package log_test
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/gopherd/log"
+)
+
+func TestEventLogUnsupported(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("eventlog is supported on windows")
+	}
+	if _, err := log.Open("eventlog:app"); err == nil {
+		t.Error("want an unsupported error, but got nil")
+	}
+}
@@ -0,0 +1,102 @@
+//go:build windows
+// +build windows
+
+package log
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSource   = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource = advapi32.NewProc("DeregisterEventSource")
+	procReportEvent           = advapi32.NewProc("ReportEventW")
+)
+
+// event types of ReportEvent
+const (
+	eventlogErrorType       = 0x0001
+	eventlogWarningType     = 0x0002
+	eventlogInformationType = 0x0004
+)
+
+// eventLog is a writer which writes logs to Windows Event Log
+type eventLog struct {
+	mu     sync.Mutex
+	handle syscall.Handle
+}
+
+// source format: source name of event log
+func openEventLog(source string) (Writer, error) {
+	if source == "" {
+		return nil, errors.New("log: source name of eventlog is empty")
+	}
+	name, err := syscall.UTF16PtrFromString(source)
+	if err != nil {
+		return nil, err
+	}
+	h, _, err := procRegisterEventSource.Call(0, uintptr(unsafe.Pointer(name)))
+	if h == 0 {
+		return nil, err
+	}
+	return &eventLog{handle: syscall.Handle(h)}, nil
+}
+
+// StripHeader implements HeaderAware StripHeader method
+func (w *eventLog) StripHeader() bool { return true }
+
+// Write implements Writer Write method
+func (w *eventLog) Write(level Level, data []byte, headerLen int) error {
+	var etype uintptr
+	switch level {
+	case LevelFatal, LevelError:
+		etype = eventlogErrorType
+	case LevelWarn:
+		etype = eventlogWarningType
+	default:
+		etype = eventlogInformationType
+	}
+	msg, err := syscall.UTF16PtrFromString(string(StripHeader(data, headerLen)))
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.handle == 0 {
+		return errNilWriter
+	}
+	r, _, err := procReportEvent.Call(
+		uintptr(w.handle),
+		etype,
+		0,                             // category
+		1,                             // event id
+		0,                             // user sid
+		1,                             // number of strings
+		0,                             // size of raw data
+		uintptr(unsafe.Pointer(&msg)), // strings
+		0,                             // raw data
+	)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Close implements Writer Close method
+func (w *eventLog) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.handle == 0 {
+		return nil
+	}
+	r, _, err := procDeregisterEventSource.Call(uintptr(w.handle))
+	w.handle = 0
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Field represents a field decoded by ParseFields
+type Field struct {
+	Key string
+	// Value is one of nil, bool, int64, uint64, float64, string, []Field for
+	// objects and []interface{} for arrays. Strings, runes and bytes are
+	// unquoted, other values like durations, complex numbers, hex-encoded
+	// bytes and NaN are strings as encoded.
+	Value interface{}
+}
+
+// ParseFields decodes top-level fields of encoded fields like {k:"v",n:1}
+// put by Context, e.g. Record.RawFields, in order. It returns nil if s is empty.
+// An error is returned if s is malformed, e.g. a value put by RawJSON is
+// invalid.
+func ParseFields(s string) ([]Field, error) {
+	if s == "" {
+		return nil, nil
+	}
+	d := fieldsDecoder{s: s}
+	fields, err := d.object()
+	if err != nil {
+		return nil, err
+	}
+	if d.skipSpace(); d.i < len(d.s) {
+		return nil, d.error()
+	}
+	return fields, nil
+}
+
+// fieldsDecoder decodes the encoding of encoder, values put by RawJSON are
+// decoded as well since JSON is accepted except escapes of strings which
+// aren't supported by Go.
+type fieldsDecoder struct {
+	s string
+	i int
+}
+
+func (d *fieldsDecoder) error() error {
+	return fmt.Errorf("log: invalid encoded fields at offset %d", d.i)
+}
+
+func (d *fieldsDecoder) skipSpace() {
+	for d.i < len(d.s) {
+		switch d.s[d.i] {
+		case ' ', '\t', '\r', '\n':
+			d.i++
+		default:
+			return
+		}
+	}
+}
+
+// next skips spaces and consumes c if it's the next byte
+func (d *fieldsDecoder) next(c byte) bool {
+	d.skipSpace()
+	if d.i < len(d.s) && d.s[d.i] == c {
+		d.i++
+		return true
+	}
+	return false
+}
+
+func (d *fieldsDecoder) object() ([]Field, error) {
+	if !d.next('{') {
+		return nil, d.error()
+	}
+	fields := []Field{}
+	if d.next('}') {
+		return fields, nil
+	}
+	for {
+		key, err := d.key()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, Field{Key: key, Value: value})
+		if d.next(',') {
+			continue
+		}
+		if d.next('}') {
+			return fields, nil
+		}
+		return nil, d.error()
+	}
+}
+
+func (d *fieldsDecoder) array() ([]interface{}, error) {
+	d.i++ // '['
+	values := []interface{}{}
+	if d.next(']') {
+		return values, nil
+	}
+	for {
+		value, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if d.next(',') {
+			continue
+		}
+		if d.next(']') {
+			return values, nil
+		}
+		return nil, d.error()
+	}
+}
+
+// key decodes a key and the following ':', keys are quoted unless they're
+// identifiers
+func (d *fieldsDecoder) key() (string, error) {
+	d.skipSpace()
+	var key string
+	if d.i < len(d.s) && d.s[d.i] == '"' {
+		s, err := d.quoted('"')
+		if err != nil {
+			return "", err
+		}
+		key = s
+	} else {
+		end := strings.IndexByte(d.s[d.i:], ':')
+		if end <= 0 {
+			return "", d.error()
+		}
+		key = strings.TrimSpace(d.s[d.i : d.i+end])
+		d.i += end
+	}
+	if !d.next(':') {
+		return "", d.error()
+	}
+	return key, nil
+}
+
+func (d *fieldsDecoder) value() (interface{}, error) {
+	d.skipSpace()
+	if d.i >= len(d.s) {
+		return nil, d.error()
+	}
+	switch c := d.s[d.i]; c {
+	case '{':
+		return d.object()
+	case '[':
+		return d.array()
+	case '"':
+		return d.quoted('"')
+	case '\'':
+		// bytes are encoded verbatim, e.g. ''' and '\'
+		if d.i+2 < len(d.s) && d.s[d.i+2] == '\'' && d.isEnd(d.i+3) {
+			d.i += 3
+			return d.s[d.i-2 : d.i-1], nil
+		}
+		return d.quoted('\'')
+	}
+	begin := d.i
+	for d.i < len(d.s) && !d.isEnd(d.i) {
+		d.i++
+	}
+	return literal(strings.TrimSpace(d.s[begin:d.i])), nil
+}
+
+// isEnd reports whether a value ends before s[i]
+func (d *fieldsDecoder) isEnd(i int) bool {
+	if i >= len(d.s) {
+		return true
+	}
+	switch d.s[i] {
+	case ',', '}', ']':
+		return true
+	}
+	return false
+}
+
+// quoted decodes a string or a rune quoted by q
+func (d *fieldsDecoder) quoted(q byte) (string, error) {
+	begin := d.i
+	for d.i++; d.i < len(d.s); d.i++ {
+		switch d.s[d.i] {
+		case '\\':
+			d.i++
+		case q:
+			d.i++
+			quoted := d.s[begin:d.i]
+			if s, err := strconv.Unquote(quoted); err == nil {
+				return s, nil
+			}
+			var s string
+			if q == '"' && json.Unmarshal([]byte(quoted), &s) == nil {
+				return s, nil
+			}
+			d.i = begin
+			return "", d.error()
+		}
+	}
+	d.i = begin
+	return "", d.error()
+}
+
+// literal decodes an unquoted value
+func literal(s string) interface{} {
+	switch s {
+	case "nil", "null":
+		return nil
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if !isNumber(s) {
+		return s
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return u
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) {
+		return f
+	}
+	return s
+}
+
+// isNumber reports whether s looks like a decimal number, e.g. 1, -2.5 or 1e3
+func isNumber(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	return s != "" && s[0] >= '0' && s[0] <= '9' && !strings.ContainsAny(s, "xXpP_")
+}
+
+// appendFieldsJSON appends fields as a JSON object
+func appendFieldsJSON(buf []byte, fields []Field) []byte {
+	buf = append(buf, '{')
+	for i, f := range fields {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSONString(buf, f.Key)
+		buf = append(buf, ':')
+		buf = appendJSONValue(buf, f.Value)
+	}
+	return append(buf, '}')
+}
+
+func appendJSONValue(buf []byte, value interface{}) []byte {
+	switch x := value.(type) {
+	case nil:
+		return append(buf, "null"...)
+	case bool:
+		return strconv.AppendBool(buf, x)
+	case int64:
+		return strconv.AppendInt(buf, x, 10)
+	case uint64:
+		return strconv.AppendUint(buf, x, 10)
+	case float64:
+		return strconv.AppendFloat(buf, x, 'f', -1, 64)
+	case string:
+		return appendJSONString(buf, x)
+	case []Field:
+		return appendFieldsJSON(buf, x)
+	case []interface{}:
+		buf = append(buf, '[')
+		for i, v := range x {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONValue(buf, v)
+		}
+		return append(buf, ']')
+	}
+	return append(buf, "null"...)
+}
+
+func appendJSONString(buf []byte, s string) []byte {
+	data, _ := json.Marshal(s)
+	return append(buf, data...)
+}
@@ -0,0 +1,86 @@
+package log_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopherd/log"
+)
+
+func TestParseFields(t *testing.T) {
+	for _, tc := range []struct {
+		fields string
+		want   []log.Field
+	}{
+		{``, nil},
+		{`{}`, []log.Field{}},
+		{`{s:"v",i:-1,u:18446744073709551615,f:1.5,b:true,z:nil}`, []log.Field{
+			{"s", "v"}, {"i", int64(-1)}, {"u", uint64(18446744073709551615)},
+			{"f", 1.5}, {"b", true}, {"z", nil},
+		}},
+		{`{"a b":1,x.y:2}`, []log.Field{{"a b", int64(1)}, {"x.y", int64(2)}}},
+		{`{s:"a,b}{[\"c:",r:'{',c:',',q:'\'',d:'''}`, []log.Field{
+			{"s", `a,b}{["c:`}, {"r", "{"}, {"c", ","}, {"q", "'"}, {"d", "'"},
+		}},
+		{`{bs:'\',e:'é',n:'\n'}`, []log.Field{{"bs", `\`}, {"e", "é"}, {"n", "\n"}}},
+		{`{d:1.5s,c:1+2i,h:0xff,nan:NaN,inf:+Inf,t:0xab…(truncated 3 bytes)}`, []log.Field{
+			{"d", "1.5s"}, {"c", "1+2i"}, {"h", "0xff"}, {"nan", "NaN"}, {"inf", "+Inf"},
+			{"t", "0xab…(truncated 3 bytes)"},
+		}},
+		{`{o:{a:[1,"x",{b:nil}],e:[]},k:1}`, []log.Field{
+			{"o", []log.Field{
+				{"a", []interface{}{int64(1), "x", []log.Field{{"b", nil}}}},
+				{"e", []interface{}{}},
+			}},
+			{"k", int64(1)},
+		}},
+		{`{j:{"a": [1, 2.5], "u":"\/"}}`, []log.Field{
+			{"j", []log.Field{{"a", []interface{}{int64(1), 2.5}}, {"u", "/"}}},
+		}},
+	} {
+		got, err := log.ParseFields(tc.fields)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.fields, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: want %#v, but got %#v", tc.fields, tc.want, got)
+		}
+	}
+	for _, fields := range []string{`k:1`, `{k:1`, `{k:"v}`, `{k}`, `{k:1}x`, `{k:[1}`, `{k:"\q"}`} {
+		if _, err := log.ParseFields(fields); err == nil {
+			t.Errorf("%s: want an error", fields)
+		}
+	}
+}
+
+func TestParseFieldsOfContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewLogger("")
+	logger.Start(log.WithOutput(&buf), log.WithFlags(0), log.WithSync(true))
+	logger.Info().
+		Byte("b", '\'').
+		Rune("r", '\'').
+		String("s", "}'\",").
+		Strings("l", []string{"[", "]"}).
+		Duration("d", time.Second).
+		RawJSON("j", []byte(`{"k":[true]}`)).
+		Print("")
+	logger.Shutdown()
+
+	fields := strings.TrimSpace(strings.TrimPrefix(buf.String(), "[I]"))
+	got, err := log.ParseFields(fields)
+	if err != nil {
+		t.Fatalf("%s: unexpected error: %v", fields, err)
+	}
+	want := []log.Field{
+		{"b", "'"}, {"r", "'"}, {"s", "}'\","},
+		{"l", []interface{}{"[", "]"}},
+		{"d", "1s"},
+		{"j", []log.Field{{"k", []interface{}{true}}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: want %#v, but got %#v", fields, want, got)
+	}
+}
@@ -0,0 +1,69 @@
+package log_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gopherd/log"
+)
+
+// syncFS is a testFS which reports names of synced files
+type syncFS struct {
+	*testFS
+	synced chan string
+}
+
+type syncedFile struct {
+	log.File
+	name   string
+	synced chan string
+}
+
+func (f syncedFile) Sync() error {
+	f.synced <- f.name
+	return nil
+}
+
+func (fs syncFS) OpenFile(name string, flag int, perm os.FileMode) (log.File, error) {
+	f, err := fs.testFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return syncedFile{File: f, name: name, synced: fs.synced}, nil
+}
+
+func TestFileFlusher(t *testing.T) {
+	fs := syncFS{testFS: newTestFS(), synced: make(chan string, 16)}
+	var writers []log.Writer
+	for _, name := range []string{"a.log", "b.log"} {
+		f, err := fs.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := log.NewWriterFromFile(f, log.FileOptions{NoBanner: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		writers = append(writers, w)
+	}
+	// closing a writer doesn't stop flushing others
+	writers[0].Close()
+	defer writers[1].Close()
+	writers[1].Write(log.LevelInfo, []byte("hello\n"), 0)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case name := <-fs.synced:
+			if name != "b.log" {
+				continue // synced by Close
+			}
+			if got := fs.files[name].content.String(); got != "hello\n" {
+				t.Errorf("want %q, but got %q", "hello\n", got)
+			}
+			return
+		case <-timeout:
+			t.Fatal("file not flushed")
+		}
+	}
+}
@@ -69,7 +69,12 @@ func main() {
 
 	for _, t := range types {
 		p()
-		p("func (enc *encoder) encode", t.sliceName(), "(s []", t.name, ") {")
+		name := t.sliceName()
+		if t.name == "byte" {
+			// encodeBytes selects encoding by options, see util.go
+			name = "HexBytes"
+		}
+		p("func (enc *encoder) encode", name, "(s []", t.name, ") {")
 		if t.name != "byte" {
 			p("\tenc.writeByte('[')")
 		} else {
@@ -0,0 +1,162 @@
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// syslogPriority returns the syslog priority of level
+func syslogPriority(level Level) int {
+	switch level {
+	case LevelFatal:
+		return 2 // crit
+	case LevelError:
+		return 3 // err
+	case LevelWarn:
+		return 4 // warning
+	case LevelInfo:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
+
+// journalField converts key to a valid journal field name: uppercase letters,
+// digits and underscores, not starting with an underscore.
+func journalField(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+			b.WriteByte(c - 'a' + 'A')
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			b.WriteByte(c)
+		case b.Len() > 0:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// appendJournalField appends a field in journal native protocol format
+func appendJournalField(buf []byte, name, value string) []byte {
+	if name == "" {
+		return buf
+	}
+	buf = append(buf, name...)
+	if strings.IndexByte(value, '\n') < 0 {
+		buf = append(buf, '=')
+		buf = append(buf, value...)
+	} else {
+		// binary safe format: name\n<little endian uint64 size>value
+		var size [8]byte
+		binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+		buf = append(buf, '\n')
+		buf = append(buf, size[:]...)
+		buf = append(buf, value...)
+	}
+	return append(buf, '\n')
+}
+
+// appendJournalEntry appends all fields of a stripped entry body which
+// formatted as `(prefix) {k1:v1,k2:v2} message`
+func appendJournalEntry(buf []byte, identifier string, level Level, body []byte) []byte {
+	buf = appendJournalField(buf, "PRIORITY", strconv.Itoa(syslogPriority(level)))
+	if identifier != "" {
+		buf = appendJournalField(buf, "SYSLOG_IDENTIFIER", identifier)
+	}
+	body = bytes.TrimSuffix(body, []byte{'\n'})
+	if len(body) > 0 && body[0] == '(' {
+		if end := bytes.Index(body, []byte(") ")); end > 0 {
+			buf = appendJournalField(buf, "PREFIX", string(body[1:end]))
+			body = body[end+2:]
+		}
+	}
+	if len(body) > 0 && body[0] == '{' {
+		if end, ok := scanFields(body, func(key, value string) {
+			buf = appendJournalField(buf, journalField(key), value)
+		}); ok {
+			body = bytes.TrimPrefix(body[end:], []byte{' '})
+		}
+	}
+	return appendJournalField(buf, "MESSAGE", string(body))
+}
+
+// scanFields scans top-level fields encoded by encoder from the beginning of
+// data, quoted keys and values are unquoted. It returns the end offset of
+// fields and whether fields are well-formed.
+func scanFields(data []byte, fn func(key, value string)) (int, bool) {
+	if len(data) == 0 || data[0] != '{' {
+		return 0, false
+	}
+	i := 1
+	for i < len(data) {
+		// key
+		end := scanValue(data, i, ':')
+		if end >= len(data) || data[end] != ':' {
+			return 0, false
+		}
+		key := unquoteField(data[i:end])
+		// value
+		i = end + 1
+		end = scanValue(data, i, ',')
+		if end >= len(data) {
+			return 0, false
+		}
+		fn(key, unquoteField(data[i:end]))
+		i = end + 1
+		if data[end] == '}' {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// scanValue returns offset of the first top-level sep or '}' from begin
+func scanValue(data []byte, begin int, sep byte) int {
+	var (
+		depth int
+		quote byte
+	)
+	for i := begin; i < len(data); i++ {
+		c := data[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '"', '\'':
+			quote = c
+		case '{', '[':
+			depth++
+		case ']':
+			depth--
+		case '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case sep:
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(data)
+}
+
+func unquoteField(s []byte) string {
+	if len(s) >= 2 && s[0] == '"' {
+		if unquoted, err := strconv.Unquote(string(s)); err == nil {
+			return unquoted
+		}
+	}
+	return string(s)
+}
@@ -0,0 +1,84 @@
+//go:build linux
+// +build linux
+
+package log
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const defaultJournalSocket = "/run/systemd/journal/socket"
+
+// journald is a writer which writes logs to systemd journal by native protocol.
+// Structured fields of entries are sent as journal fields with uppercased keys.
+// NOTE: entries larger than the max datagram size of the socket are dropped
+// with an error returned.
+type journald struct {
+	identifier string
+
+	mu   sync.Mutex
+	conn *net.UnixConn
+	buf  []byte
+}
+
+// journaldQueryKeys holds the recognized query keys of journald source
+var journaldQueryKeys = map[string]bool{
+	"strict": true,
+	"socket": true,
+}
+
+// source format: [identifier][?socket=path], unknown query keys are rejected
+// if query strict is true, see openFile
+func openJournald(source string) (Writer, error) {
+	var (
+		identifier = source
+		socket     = defaultJournalSocket
+	)
+	if i := strings.Index(source, "?"); i >= 0 {
+		identifier = source[:i]
+		q, err := url.ParseQuery(source[i+1:])
+		if err != nil {
+			return nil, errors.New("log: invalid source for journald: " + source)
+		}
+		if err := checkQueryKeys(q, journaldQueryKeys, source); err != nil {
+			return nil, err
+		}
+		if s := q.Get("socket"); s != "" {
+			socket = s
+		}
+	}
+	if identifier == "" {
+		identifier = filepath.Base(os.Args[0])
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journald{
+		identifier: identifier,
+		conn:       conn,
+	}, nil
+}
+
+// StripHeader implements HeaderAware StripHeader method
+func (w *journald) StripHeader() bool { return true }
+
+// Write implements Writer Write method
+func (w *journald) Write(level Level, data []byte, headerLen int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = appendJournalEntry(w.buf[:0], w.identifier, level, StripHeader(data, headerLen))
+	_, err := w.conn.Write(w.buf)
+	return err
+}
+
+// Close implements Writer Close method
+func (w *journald) Close() error {
+	return w.conn.Close()
+}
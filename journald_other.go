@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package log
+
+import (
+	"errors"
+	"runtime"
+)
+
+func openJournald(source string) (Writer, error) {
+	return nil, errors.New("log: journald is unsupported on " + runtime.GOOS)
+}
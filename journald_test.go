@@ -0,0 +1,50 @@
+package log_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/gopherd/log"
+)
+
+func TestJournald(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("journald is supported only on linux")
+	}
+	dir, err := ioutil.TempDir("", "journald")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	writer, err := log.Open("journald:app?socket=" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.NewLogger("testing")
+	logger.Start(log.WithWriters(writer), log.WithSync(true))
+	logger.Warn().Int("user-id", 1).String("name", "a\nb").Print("hello")
+	logger.Shutdown()
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	want := "PRIORITY=4\nSYSLOG_IDENTIFIER=app\nPREFIX=testing\nUSER_ID=1\n" +
+		"NAME\n\x03\x00\x00\x00\x00\x00\x00\x00a\nb\nMESSAGE=hello\n"
+	if got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+}
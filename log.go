@@ -1,14 +1,21 @@
 package log
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
+	"unsafe"
 )
 
 // These flags define which text to prefix to each log entry generated by the Logger.
@@ -19,6 +26,7 @@ const (
 	Lmicroseconds                              // microsecond resolution: 01:23:23.123123.  assumes Ltimestamp.
 	Lshortfile                                 // final file name element and line number: d.go:23. overrides Llongfile
 	Llongfile                                  // full file name and line number: /a/b/c/d.go:23
+	Lgoid                                      // id of the logging goroutine: #42, it's expensive, see GoID
 	LdefaultFlags = Ltimestamp | Lmicroseconds // default values for the standard logger
 )
 
@@ -51,6 +59,7 @@ func getLevelByte(level Level) byte {
 var (
 	errIsCloneLogger     = errors.New("log: logger is a clone")
 	errUnrecognizedLevel = errors.New("log: unrecognized level")
+	errProviderClosed    = errors.New("log: provider closed")
 )
 
 func (level Level) index() int { return int(level - 1) }
@@ -101,21 +110,90 @@ func (level Level) MarshalJSON() ([]byte, error) {
 	return []byte(`"` + level.String() + `"`), nil
 }
 
-// UnmarshalJSON implements json.Unmarshaler
+// UnmarshalJSON implements json.Unmarshaler, it accepts either a name which
+// could be parsed by ParseLevel or a number in range [LevelFatal, LevelTrace].
+// Unknown names or numbers are rejected and the level is left unchanged,
+// null is a no-op.
 func (level *Level) UnmarshalJSON(data []byte) error {
-	var (
-		s   string
-		err error
-	)
-	if len(data) >= 2 {
-		s, err = strconv.Unquote(string(data))
-		if err != nil {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if len(s) > 0 && s[0] == '"' {
+		var err error
+		if s, err = strconv.Unquote(s); err != nil {
 			return err
 		}
 	} else {
-		s = string(data)
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.New("log: invalid JSON level " + s)
+		}
+		if n < int(LevelFatal) || n > int(LevelTrace) {
+			return errUnrecognizedLevel
+		}
+		*level = Level(n)
+		return nil
 	}
-	return level.Set(s)
+	lv, ok := ParseLevel(s)
+	if !ok {
+		return errUnrecognizedLevel
+	}
+	*level = lv
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (level Level) MarshalText() ([]byte, error) {
+	return []byte(level.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, it accepts strings
+// accepted by ParseLevel. Unknown levels are rejected and the level is left
+// unchanged like UnmarshalJSON.
+func (level *Level) UnmarshalText(text []byte) error {
+	lv, ok := ParseLevel(string(text))
+	if !ok {
+		return errUnrecognizedLevel
+	}
+	*level = lv
+	return nil
+}
+
+// ColorReset is the ANSI sequence which resets colors
+const ColorReset = "\x1b[0m"
+
+// levelColors holds ANSI colors of levels, the default theme is registered by init
+var levelColors struct {
+	sync.RWMutex
+	m map[Level]string
+}
+
+func init() {
+	levelColors.m = map[Level]string{
+		LevelFatal: "\x1b[1;35m", // bold magenta
+		LevelError: "\x1b[31m",   // red
+		LevelWarn:  "\x1b[33m",   // yellow
+		LevelInfo:  "\x1b[32m",   // green
+		LevelDebug: "\x1b[36m",   // cyan
+		LevelTrace: "\x1b[90m",   // gray
+	}
+}
+
+// RegisterLevelColor registers an ANSI color sequence for level, it replaces
+// the color of a builtin level or defines a color for a custom level.
+func RegisterLevelColor(level Level, color string) {
+	levelColors.Lock()
+	defer levelColors.Unlock()
+	levelColors.m[level] = color
+}
+
+// Color returns the ANSI color sequence of level, or an empty string if no
+// color registered for the level
+func (level Level) Color() string {
+	levelColors.RLock()
+	defer levelColors.RUnlock()
+	return levelColors.m[level]
 }
 
 // MoreVerboseThan returns whether level more verbose than other
@@ -141,10 +219,40 @@ func ParseLevel(s string) (lv Level, ok bool) {
 	return LevelInfo, false
 }
 
+// Clock provides the current time, it's used for timestamps of headers by
+// WithClock and rotation of file writers by FileOptions.Clock. A shared clock
+// makes it possible to test formatting and rotation together.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc is an adapter to allow the use of ordinary functions as Clock
+type ClockFunc func() time.Time
+
+// Now implements Clock Now method
+func (f ClockFunc) Now() time.Time { return f() }
+
+// stdClock is the default clock which returns time.Now
+type stdClock struct{}
+
+func (stdClock) Now() time.Time { return time.Now() }
+
 // Caller holds caller information
 type Caller struct {
 	Filename string
 	Line     int
+	PC       uintptr // program counter of the caller, or 0
+}
+
+// Func returns the full name of the function of the caller, or empty
+func (c Caller) Func() string {
+	if c.PC == 0 {
+		return ""
+	}
+	if fn := runtime.FuncForPC(c.PC); fn != nil {
+		return fn.Name()
+	}
+	return ""
 }
 
 type options struct {
@@ -154,6 +262,45 @@ type options struct {
 	provider Provider
 	writers  []Writer
 	errors   []error
+
+	fatalDump   *RingWriter
+	fatalDumpTo io.Writer
+	exit        func(code int)
+	clock       Clock
+
+	encoding encoderOptions
+
+	reopenSignals []os.Signal
+	errorHandler  func(error)
+
+	levelCounter func(Level)
+
+	queueCapacity int
+	queuePolicy   OverflowPolicy
+	maxEntrySize  int
+
+	levelRules map[string]Level
+	trimPaths  []string
+
+	colored    bool
+	colorTheme map[Level]string
+
+	longLevel  bool
+	noHeader   bool
+	lineEnding string
+
+	repanic bool
+
+	fieldFuncs []func(*Context)
+
+	traceExtractor TraceExtractor
+
+	sampler   Sampler
+	sampleKey SampleKeyFunc
+
+	poolWarmup int
+
+	repeatSuppression bool
 }
 
 func defaultOptions() options {
@@ -201,6 +348,26 @@ func WithLevel(level Level) Option {
 	}
 }
 
+// WithLevelRules sets levels by prefix, the key is a prefix matched as
+// leading "/"-separated segments of the logger's prefix, e.g. "db" matches
+// prefixes "db" and "db/pool" but not "dbx". The level of the most specific
+// rule overrides the logger's level for entries under the prefix.
+//
+//	log.Start(log.WithLevel(log.LevelInfo), log.WithLevelRules(map[string]log.Level{
+//		"db":   log.LevelWarn,
+//		"auth": log.LevelDebug,
+//	}))
+func WithLevelRules(rules map[string]Level) Option {
+	return func(opt *options) {
+		if opt.levelRules == nil {
+			opt.levelRules = make(map[string]Level, len(rules))
+		}
+		for prefix, level := range rules {
+			opt.levelRules[prefix] = level
+		}
+	}
+}
+
 // WithProvider specify custom provider
 func WithProvider(provider Provider) Option {
 	if provider == nil {
@@ -242,9 +409,20 @@ func WithOutput(w io.Writer) Option {
 	return WithWriters(newConsole(w))
 }
 
+// WithConsole appends a console writer opened by source like the registered
+// "console" writer, i.e. stdout, stderr (default) or split which writes
+// entries of error and fatal levels to stderr and others to stdout
+func WithConsole(source string) Option {
+	w, err := openConsole(source)
+	if err != nil {
+		return errOption(err)
+	}
+	return WithWriters(w)
+}
+
 // WithFile appends a file writer
 func WithFile(fileOptions FileOptions) Option {
-	f, err := newFile(fileOptions)
+	f, err := newFileWriter(fileOptions)
 	if err != nil {
 		return errOption(err)
 	}
@@ -253,7 +431,368 @@ func WithFile(fileOptions FileOptions) Option {
 
 // WithMultiFile appends a multifile writer
 func WithMultiFile(multiFileOptions MultiFileOptions) Option {
-	return WithWriters(newMultiFile(multiFileOptions))
+	return WithWriters(newMultiFileWriter(multiFileOptions))
+}
+
+// WithDedupeKeys removes previous field which has the same key when a field
+// appended to the context, i.e. the last wins. By default, all fields are
+// kept even if the keys are duplicated, e.g.
+//
+//	log.Info().String("id", "a").String("id", "b").Print("msg") // {id:"a",id:"b"} msg
+//
+// Base fields of ContextLogger and fields put by field funcs are deduplicated
+// with fields of the context as well.
+func WithDedupeKeys(yes bool) Option {
+	return func(opt *options) {
+		opt.encoding.dedupeKeys = yes
+	}
+}
+
+// WithBytesEncoding sets the encoding of byte slices (default: HexBytes)
+func WithBytesEncoding(encoding BytesEncoding) Option {
+	return func(opt *options) {
+		opt.encoding.bytesEncoding = encoding
+	}
+}
+
+// WithRedactKeys masks values of fields whose key matches any of keys
+// case-insensitively, including fields of nested objects and keys of maps,
+// e.g.
+//
+//	log.Start(log.WithRedactKeys("password", "token"))
+//	log.Info().String("Password", "123456").Print("login") // {Password:"******"} login
+func WithRedactKeys(keys ...string) Option {
+	var copied = make([]string, len(keys))
+	copy(copied, keys)
+	return func(opt *options) {
+		opt.encoding.redactKeys = append(opt.encoding.redactKeys, copied...)
+	}
+}
+
+// WithRedactMask sets the mask of values redacted by WithRedactKeys (default: ******)
+func WithRedactMask(mask string) Option {
+	return func(opt *options) {
+		opt.encoding.redactMask = mask
+	}
+}
+
+// WithFieldTransform sets a function which transforms keys of fields, it
+// returns the new key, or skip=true to drop the field, e.g.
+//
+//	log.Start(log.WithFieldTransform(func(key string) (string, bool) {
+//		if key == "msg" {
+//			return "message", false
+//		}
+//		return key, key == "debug"
+//	}))
+//
+// It's called for every field including fields of nested objects and keys of
+// maps. Redaction and deduplication apply to the new key.
+func WithFieldTransform(fn func(key string) (newKey string, skip bool)) Option {
+	return func(opt *options) {
+		opt.encoding.fieldTransform = fn
+	}
+}
+
+// WithFieldsPosition sets the position of fields put by Context relative to
+// the message (default: FieldsBefore), e.g. FieldsAfter prints entries like
+// `message {k:"v"}`. Records of structured writers have no fields split from
+// the message if fields are after the message.
+func WithFieldsPosition(pos FieldsPosition) Option {
+	return func(opt *options) {
+		opt.encoding.fieldsPosition = pos
+	}
+}
+
+// WithNonFinitePolicy sets how to encode non-finite floats, i.e. NaN and ±Inf
+// (default: NonFiniteLiteral)
+func WithNonFinitePolicy(policy NonFinitePolicy) Option {
+	return func(opt *options) {
+		opt.encoding.nonFinite = policy
+	}
+}
+
+// WithMaxFieldLen truncates string and bytes values longer than n bytes, the
+// truncated value ends with a marker like "…(truncated 100 bytes)". String
+// values are truncated at a rune boundary. It's a no-op if n <= 0 (default).
+func WithMaxFieldLen(n int) Option {
+	return func(opt *options) {
+		opt.encoding.maxFieldLen = n
+	}
+}
+
+// WithBufferPoolMaxSize sets the max capacity of context and entry buffers which
+// could be retained for reusing (default: 1024). Increasing it trades memory for
+// fewer allocations if large entries are logged consistently.
+func WithBufferPoolMaxSize(size int) Option {
+	return func(opt *options) {
+		opt.encoding.bufferPoolMaxSize = size
+	}
+}
+
+// WithEncoderPoolWarmup puts n contexts and entries with preallocated buffers
+// into their pools at Start, so that the first burst of logs doesn't pay for
+// allocations. It's optional, n should be sized to the expected number of
+// goroutines logging concurrently. NOTE: Pooled objects may be released by
+// the garbage collector.
+func WithEncoderPoolWarmup(n int) Option {
+	return func(opt *options) {
+		opt.poolWarmup = n
+	}
+}
+
+// WithReopenSignal reopens all writers which implement Reopener (e.g. file,
+// multifile) when any of the signals received. It's useful for cooperating
+// with external rotation tools such as logrotate, e.g.
+//
+//	log.Start(log.WithFile(options), log.WithReopenSignal(syscall.SIGHUP))
+func WithReopenSignal(signals ...os.Signal) Option {
+	return func(opt *options) {
+		opt.reopenSignals = append(opt.reopenSignals, signals...)
+	}
+}
+
+// WithLevelCounter sets a function which called with level for every emitted
+// entry once it's queued or written. Entries dropped by DropNewest or printed
+// after shutdown aren't counted, but entries evicted by DropOldest have been
+// counted when queued. It should be fast and must be safe for concurrent use.
+// It's useful for counting entries, e.g. by prometheus (see wrapper/promlog):
+//
+//	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+//		Name: "log_entries_total",
+//		Help: "Number of log entries by level.",
+//	}, []string{"level"})
+//	log.Start(log.WithLevelCounter(func(level log.Level) {
+//		counter.WithLabelValues(level.String()).Inc()
+//	}))
+//
+// NOTE: It works only for the built in provider.
+func WithLevelCounter(counter func(Level)) Option {
+	return func(opt *options) {
+		opt.levelCounter = counter
+	}
+}
+
+// OverflowPolicy represents the policy of bounded queue when it's full
+type OverflowPolicy int
+
+// OverflowPolicy constants
+const (
+	Block      OverflowPolicy = iota // blocks until the queue is not full
+	DropNewest                       // drops the entry to be queued
+	DropOldest                       // drops the oldest entry in queue
+)
+
+// WithQueue bounds the queue of async mode with capacity, policy is used when
+// the queue is full. The queue is unbounded by default or if capacity <= 0.
+// Number of dropped entries could be got by Logger.Dropped.
+// NOTE: It works only for the built in provider.
+func WithQueue(capacity int, policy OverflowPolicy) Option {
+	return func(opt *options) {
+		opt.queueCapacity = capacity
+		opt.queuePolicy = policy
+	}
+}
+
+// WithMaxEntrySize truncates the body of entries larger than n bytes, the
+// header is never truncated and the truncated body ends with a marker like
+// "…(truncated 100 bytes)". The stack trace of fatal entries is truncated
+// first. Number of truncated entries could be got by Logger.Truncated.
+// NOTE: It works only for the built in provider.
+func WithMaxEntrySize(n int) Option {
+	return func(opt *options) {
+		opt.maxEntrySize = n
+	}
+}
+
+// WithErrorHandler sets the handler of errors which occur in background
+// goroutines of the logger, e.g. reopening writers on signals. Errors are
+// printed to stderr if no handler set.
+func WithErrorHandler(handler func(error)) Option {
+	return func(opt *options) {
+		opt.errorHandler = handler
+	}
+}
+
+// WithFatalDump dumps entries held by ring to w before exiting on fatal.
+// NOTE: It works only for the built in provider.
+func WithFatalDump(ring *RingWriter, w io.Writer) Option {
+	if ring == nil || w == nil {
+		panic("log: with a nil fatal dump ring or writer")
+	}
+	return func(opt *options) {
+		opt.fatalDump = ring
+		opt.fatalDumpTo = w
+	}
+}
+
+// WithExitFunc replaces os.Exit called after a fatal entry has been written,
+// e.g. to capture fatal in tests.
+// NOTE: It works only for the built in provider.
+func WithExitFunc(exit func(code int)) Option {
+	if exit == nil {
+		panic("log: with a nil exit func")
+	}
+	return func(opt *options) {
+		opt.exit = exit
+	}
+}
+
+// WithLevelFormat sets whether the level is written in header as a single
+// character like [I] (the default) or the full name like [INFO].
+// NOTE: It works only for the built in provider.
+func WithLevelFormat(short bool) Option {
+	return func(opt *options) {
+		opt.longLevel = !short
+	}
+}
+
+// WithNoHeader sets whether the header is omitted, entries then contain only
+// the prefix, fields and message, and writers get a zero headerLen. It's
+// useful for libraries which embed entries into an outer format, use it with
+// WithFlags(0) to skip looking up callers as well.
+// NOTE: It works only for the built in provider.
+func WithNoHeader(yes bool) Option {
+	return func(opt *options) {
+		opt.noHeader = yes
+	}
+}
+
+// WithLineEnding sets the line ending of entries (default: "\n"), e.g. "\r\n"
+// for Windows consumers. It ends lines of the stack trace of fatal entries
+// as well, and it's not appended again if the message already ends with it.
+// WithMaxEntrySize limits fatal entries before their stack trace lines are
+// ended by it.
+// NOTE: It works only for the built in provider.
+func WithLineEnding(ending string) Option {
+	if ending == "" {
+		panic("log: with an empty line ending")
+	}
+	return func(opt *options) {
+		opt.lineEnding = ending
+	}
+}
+
+// WithRepeatSuppression sets whether consecutive entries with the same level
+// and body (the entry without header) are suppressed like syslog. The summary
+// like "... (repeated 3 times)" is written before the next different entry,
+// or after 30 seconds if there is no such entry, or on shutdown.
+// NOTE: It works only for the built in provider.
+func WithRepeatSuppression(yes bool) Option {
+	return func(opt *options) {
+		opt.repeatSuppression = yes
+	}
+}
+
+// WithFieldFunc appends a function which puts dynamic fields into every
+// context before any other field, e.g.
+//
+//	log.Start(log.WithFieldFunc(func(ctx *log.Context) {
+//		ctx.String("trace_id", currentTraceID())
+//	}))
+//
+// Functions are called in order only if the context passed the level check,
+// they apply to contexts only, i.e. not to Printf-like functions.
+func WithFieldFunc(fn func(ctx *Context)) Option {
+	if fn == nil {
+		panic("log: with a nil field func")
+	}
+	return func(opt *options) {
+		opt.fieldFuncs = append(opt.fieldFuncs, fn)
+	}
+}
+
+// TraceExtractor extracts the trace id and span id from ctx, it returns empty
+// strings if ctx doesn't carry a trace
+type TraceExtractor func(ctx context.Context) (traceID, spanID string)
+
+// WithTraceExtractor sets the extractor used by Context.FromTraceContext, e.g.
+//
+//	log.Start(log.WithTraceExtractor(func(ctx context.Context) (string, string) {
+//		sc := trace.SpanContextFromContext(ctx)
+//		if !sc.IsValid() {
+//			return "", ""
+//		}
+//		return sc.TraceID().String(), sc.SpanID().String()
+//	}))
+func WithTraceExtractor(extractor TraceExtractor) Option {
+	return func(opt *options) {
+		opt.traceExtractor = extractor
+	}
+}
+
+// WithSampler sets the sampler which decides whether entries are logged, fatal
+// entries are always logged. Entries are sampled by templates rather than
+// rendered messages, i.e. the format of Printf-like functions and the message
+// of Print, so "user %d failed" with varying ids is sampled as one site. See
+// NewSampler and WithSampleKey. A TimedSampler is called with the time read by
+// the clock of the logger, see WithClock.
+func WithSampler(sampler Sampler) Option {
+	return func(opt *options) {
+		opt.sampler = sampler
+	}
+}
+
+// WithSampleKey sets the function which derives sampling keys from templates
+// (default: the template itself), e.g. to sample by a normalized message.
+func WithSampleKey(fn SampleKeyFunc) Option {
+	return func(opt *options) {
+		opt.sampleKey = fn
+	}
+}
+
+// WithRepanic sets whether Recover and RecoverWith panic again after the
+// recovered value logged
+func WithRepanic(yes bool) Option {
+	return func(opt *options) {
+		opt.repanic = yes
+	}
+}
+
+// WithColorTheme colorizes headers of console writers with the theme which
+// overrides colors of levels, i.e. Level.Color. The default theme is used if
+// theme is nil, e.g.
+//
+//	log.Start(log.WithOutput(os.Stderr), log.WithColorTheme(nil))
+func WithColorTheme(theme map[Level]string) Option {
+	var copied map[Level]string
+	if len(theme) > 0 {
+		copied = make(map[Level]string, len(theme))
+		for level, color := range theme {
+			copied[level] = color
+		}
+	}
+	return func(opt *options) {
+		opt.colored = true
+		opt.colorTheme = copied
+	}
+}
+
+// WithTrimPath strips the longest matching prefix from file names of callers
+// if flag Llongfile is set and Lshortfile is not, e.g. prefix
+// "/home/ci/go/src" turns "/home/ci/go/src/org/repo/pkg/file.go" into
+// "org/repo/pkg/file.go". Prefixes are matched at "/" boundaries.
+// NOTE: It works only for the built in provider and Context.Caller.
+func WithTrimPath(prefixes ...string) Option {
+	return func(opt *options) {
+		opt.trimPaths = append(opt.trimPaths, prefixes...)
+	}
+}
+
+// WithClock sets the clock used for the timestamp of header, pass the same
+// clock to FileOptions.Clock to share it with file writers, e.g.
+//
+//	clock := newFakeClock()
+//	log.Start(log.WithClock(clock), log.WithFile(log.FileOptions{Rotate: true, Clock: clock}))
+//
+// NOTE: It works only for the built in provider.
+func WithClock(clock Clock) Option {
+	if clock == nil {
+		panic("log: with a nil clock")
+	}
+	return func(opt *options) {
+		opt.clock = clock
+	}
 }
 
 // Printer is an interface used to create context or print message
@@ -286,14 +825,38 @@ type Logger struct {
 	level    int32
 	flags    int32
 	clone    bool
+	encoding encoderOptions
+	rules    []levelRule      // sorted by length of prefix in descending order
+	trims    []string         // trimmed prefixes of file names, see WithTrimPath
+	repanic  bool             // panics again after recovered, see WithRepanic
+	fields   []func(*Context) // put dynamic fields, see WithFieldFunc
+	traces   TraceExtractor   // see WithTraceExtractor
+	sampler  Sampler          // see WithSampler
+	sampleBy SampleKeyFunc    // see WithSampleKey
+	clock    Clock            // see WithClock
+	limits   *printLimits     // see Once and Every
+
+	writers    *atomic.Value // []Writer specified by WithWriters, WithFile, etc., shared by clones
+	onError    func(error)   // see WithErrorHandler
+	signalStop chan struct{} // used to stop watching reopen signals
+	levelFile  *levelWatcher // see WatchLevelFile
+}
+
+// levelWatcher holds the watching of the level file
+type levelWatcher struct {
+	mu   sync.Mutex
+	stop chan struct{} // used to stop watching the level file
 }
 
 // NewLogger creates a logger with prefix
 func NewLogger(prefix string) *Logger {
 	return &Logger{
-		provider: empty,
-		level:    int32(LevelInfo),
-		prefix:   prefix,
+		provider:  empty,
+		level:     int32(LevelInfo),
+		prefix:    prefix,
+		limits:    newPrintLimits(),
+		writers:   new(atomic.Value),
+		levelFile: new(levelWatcher),
 	}
 }
 
@@ -310,6 +873,13 @@ func (logger *Logger) Start(options ...Option) error {
 		}
 		return opt.errors[0]
 	}
+	if opt.colored {
+		for _, w := range opt.writers {
+			if c, ok := w.(interface{ setColorTheme(map[Level]string) }); ok {
+				c.setColorTheme(opt.colorTheme)
+			}
+		}
+	}
 	async := !opt.sync
 	changed := true
 	if opt.provider == nil {
@@ -318,25 +888,256 @@ func (logger *Logger) Start(options ...Option) error {
 			opt.provider = logger.provider
 			changed = false
 		case 1:
-			opt.provider = newProvider(opt.writers[0], async)
+			opt.provider = newProvider(opt.writers[0], async, &opt)
 		default:
-			opt.provider = newProvider(multiWriter{opt.writers}, async)
+			opt.provider = newProvider(multiWriter{opt.writers}, async, &opt)
 		}
 	}
 	if opt.level != 0 {
 		logger.SetLevel(opt.level)
 	}
 	logger.SetFlags(opt.flags)
+	logger.encoding = opt.encoding
+	logger.rules = newLevelRules(opt.levelRules)
+	logger.trims = newTrimPaths(opt.trimPaths)
+	logger.repanic = opt.repanic
+	logger.fields = opt.fieldFuncs
+	logger.traces = opt.traceExtractor
+	logger.sampler = opt.sampler
+	logger.sampleBy = opt.sampleKey
+	logger.onError = opt.errorHandler
+	logger.clock = opt.clock
+	if opt.poolWarmup > 0 {
+		warmupPools(opt.poolWarmup, opt.encoding.bufferPoolMaxSize)
+	}
 
 	if changed {
 		logger.Shutdown()
 		logger.provider = opt.provider
-		return logger.provider.Start()
+		logger.writers.Store(opt.writers)
+		if err := logger.provider.Start(); err != nil {
+			return err
+		}
+		if len(opt.reopenSignals) > 0 {
+			logger.watchReopenSignals(opt.reopenSignals)
+		}
+	}
+	return nil
+}
+
+func (logger *Logger) watchReopenSignals(signals []os.Signal) {
+	var (
+		ch   = make(chan os.Signal, 1)
+		stop = make(chan struct{})
+	)
+	logger.signalStop = stop
+	signal.Notify(ch, signals...)
+	handleError := logger.errorHandler()
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ch:
+				if err := logger.Reopen(); err != nil {
+					handleError(fmt.Errorf("log: reopen writers: %w", err))
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// WatchLevelFile reads the level (see ParseLevel) from the file every interval
+// and sets it if changed, it's useful for changing the level at runtime
+// without signals, e.g. by a mounted ConfigMap in Kubernetes:
+//
+//	log.Start(log.WithFile(options))
+//	log.WatchLevelFile("/etc/app/loglevel", 10*time.Second)
+//
+// The error of the first read is returned, the file is watched regardless and
+// later errors are reported to the error handler (see WithErrorHandler) once
+// until the error changes. Watching is stopped by Shutdown or another call of
+// WatchLevelFile, so it should be called after the logger started.
+func (logger *Logger) WatchLevelFile(path string, interval time.Duration) error {
+	if logger.clone {
+		return errIsCloneLogger
+	}
+	if interval <= 0 {
+		return errors.New("log: watch level file with a non-positive interval")
+	}
+	stop := make(chan struct{})
+	w := logger.levelFile
+	w.mu.Lock()
+	w.stopLocked()
+	w.stop = stop
+	w.mu.Unlock()
+	err := logger.readLevelFile(path)
+	handleError := logger.errorHandler()
+	go func(lastErr error) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := logger.readLevelFile(path)
+				if err != nil && (lastErr == nil || err.Error() != lastErr.Error()) {
+					handleError(err)
+				}
+				lastErr = err
+			case <-stop:
+				return
+			}
+		}
+	}(err)
+	return err
+}
+
+// stopWatching stops watching the level file
+func (w *levelWatcher) stopWatching() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopLocked()
+}
+
+func (w *levelWatcher) stopLocked() {
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+}
+
+// readLevelFile reads the level from the file and sets it if changed
+func (logger *Logger) readLevelFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("log: read level file: %w", err)
+	}
+	s := strings.TrimSpace(string(data))
+	level, ok := ParseLevel(s)
+	if !ok {
+		return errors.New("log: invalid level " + strconv.Quote(s) + " in " + path)
+	}
+	if logger.GetLevel() != level {
+		logger.SetLevel(level)
 	}
 	return nil
 }
 
-// Clone clones the logger with new prefix
+// Dropped returns the number of entries dropped by the provider
+func (logger *Logger) Dropped() int64 {
+	if p, ok := logger.provider.(interface{ Dropped() int64 }); ok {
+		return p.Dropped()
+	}
+	return 0
+}
+
+// Truncated returns the number of entries truncated by the provider
+func (logger *Logger) Truncated() int64 {
+	if p, ok := logger.provider.(interface{ Truncated() int64 }); ok {
+		return p.Truncated()
+	}
+	return 0
+}
+
+// SetWriter replaces writers of the started logger by w, level, flags and the
+// async goroutine are kept. Entries printed before the call are written to the
+// old writers which are closed after that. It's safe to print concurrently,
+// but SetWriter shouldn't be called concurrently with Start, Rotate or Reopen.
+func (logger *Logger) SetWriter(w Writer) error {
+	if w == nil {
+		panic("log: set a nil writer")
+	}
+	if logger.clone {
+		return errIsCloneLogger
+	}
+	p, ok := logger.provider.(interface {
+		setWriter(Writer) (Writer, error)
+	})
+	if !ok {
+		return errors.New("log: provider doesn't support SetWriter")
+	}
+	old, err := p.setWriter(w)
+	if err != nil {
+		return err
+	}
+	logger.writers.Store([]Writer{w})
+	return old.Close()
+}
+
+// SetOutput replaces writers of the started logger by a console writer with
+// specified io.Writer, see SetWriter
+func (logger *Logger) SetOutput(w io.Writer) error {
+	return logger.SetWriter(newConsole(w))
+}
+
+// loadWriters returns writers specified by options of Start or SetWriter
+func (logger *Logger) loadWriters() []Writer {
+	writers, _ := logger.writers.Load().([]Writer)
+	return writers
+}
+
+// errorHandler returns the handler of errors which occur in background
+// goroutines, it should be obtained before starting the goroutine, see
+// WithErrorHandler
+func (logger *Logger) errorHandler() func(error) {
+	if logger.onError != nil {
+		return logger.onError
+	}
+	return func(err error) { fmt.Fprintln(os.Stderr, err) }
+}
+
+// Rotate rotates all writers which implement Rotatable
+func (logger *Logger) Rotate() error {
+	var lastErr error
+	for _, w := range logger.loadWriters() {
+		if r, ok := w.(Rotatable); ok {
+			if err := r.Rotate(); err != nil {
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+// Reopen reopens all writers which implement Reopener
+func (logger *Logger) Reopen() error {
+	var lastErr error
+	for _, w := range logger.loadWriters() {
+		if r, ok := w.(Reopener); ok {
+			if err := r.Reopen(); err != nil {
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+// WritersHealth returns the health of each writer in order of writers, the
+// error is nil if the writer is healthy or doesn't implement HealthChecker
+func (logger *Logger) WritersHealth() []error {
+	writers := logger.loadWriters()
+	errs := make([]error, len(writers))
+	for i, w := range writers {
+		errs[i] = healthy(w)
+	}
+	return errs
+}
+
+// Clone clones the logger with new prefix. The clone shares the provider and
+// writers of the logger but has its own level and flags, so it could be tuned
+// by SetLevel and SetFlags without touching the logger, e.g.
+//
+//	db := log.Default().Clone("db")
+//	db.SetLevel(log.LevelTrace)
+//
+// The clone can't be started or shut down. It keeps the provider and options
+// of the logger at the time of cloning, so it should be cloned after the
+// logger started, and it stops logging once the logger is shut down or
+// restarted with other writers. Writers replaced by SetWriter are shared with
+// the clone, i.e. its Rotate, Reopen and WritersHealth act on the writers of
+// the logger. It's safe to clone the logger concurrently with logging and
+// SetWriter, but not with Start.
 func (logger *Logger) Clone(prefix string) *Logger {
 	newLogger := *logger
 	newLogger.prefix = prefix
@@ -344,11 +1145,24 @@ func (logger *Logger) Clone(prefix string) *Logger {
 	return &newLogger
 }
 
+// now returns the current time by the clock set by WithClock
+func (logger *Logger) now() time.Time {
+	if logger.clock != nil {
+		return logger.clock.Now()
+	}
+	return time.Now()
+}
+
 // Shutdown shutdowns the logger
 func (logger *Logger) Shutdown() error {
 	if logger.clone {
 		return errIsCloneLogger
 	}
+	if logger.signalStop != nil {
+		close(logger.signalStop)
+		logger.signalStop = nil
+	}
+	logger.levelFile.stopWatching()
 	return logger.provider.Shutdown()
 }
 
@@ -367,6 +1181,36 @@ func (logger *Logger) GetLevel() Level {
 	return Level(atomic.LoadInt32(&logger.level))
 }
 
+type levelRule struct {
+	prefix string
+	level  Level
+}
+
+func newLevelRules(m map[string]Level) []levelRule {
+	if len(m) == 0 {
+		return nil
+	}
+	rules := make([]levelRule, 0, len(m))
+	for prefix, level := range m {
+		rules = append(rules, levelRule{prefix: prefix, level: level})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return len(rules[i].prefix) > len(rules[j].prefix)
+	})
+	return rules
+}
+
+// levelOf returns the level for entries with the prefix
+func (logger *Logger) levelOf(prefix string) Level {
+	for _, r := range logger.rules {
+		if r.prefix == "" || prefix == r.prefix ||
+			(strings.HasPrefix(prefix, r.prefix) && prefix[len(r.prefix)] == '/') {
+			return r.level
+		}
+	}
+	return logger.GetLevel()
+}
+
 // SetLevel sets the log level
 func (logger *Logger) SetLevel(level Level) {
 	atomic.StoreInt32(&logger.level, int32(level))
@@ -381,7 +1225,7 @@ func (logger *Logger) If(ok bool) Printer {
 }
 
 func (logger *Logger) logf(level Level, format string, args ...interface{}) {
-	if logger.GetLevel() < level {
+	if logger.levelOf(logger.prefix) < level || !logger.sampled(level, format, time.Time{}) {
 		return
 	}
 	var (
@@ -389,11 +1233,29 @@ func (logger *Logger) logf(level Level, format string, args ...interface{}) {
 		flags  = logger.GetFlags()
 	)
 	if flags&(Lshortfile|Llongfile) != 0 {
-		_, caller.Filename, caller.Line, _ = runtime.Caller(2)
+		caller.PC, caller.Filename, caller.Line, _ = runtime.Caller(2)
 	}
 	logger.provider.Print(level, flags, caller, logger.prefix, fmt.Sprintf(format, args...))
 }
 
+// sampled reports whether the entry of template should be logged by the
+// sampler, now is the time of the entry or zero if the clock is not read yet
+func (logger *Logger) sampled(level Level, template string, now time.Time) bool {
+	if logger.sampler == nil || level == LevelFatal {
+		return true
+	}
+	if logger.sampleBy != nil {
+		template = logger.sampleBy(level, template)
+	}
+	if s, ok := logger.sampler.(TimedSampler); ok {
+		if now.IsZero() {
+			now = logger.now()
+		}
+		return s.SampleAt(level, template, now)
+	}
+	return logger.sampler.Sample(level, template)
+}
+
 // Logf prints log with format
 func (logger *Logger) Logf(level Level, format string, args ...interface{}) {
 	logger.logf(level, format, args...)
@@ -452,7 +1314,11 @@ func (logger *Logger) Log(level Level) *Context { return getContext(logger, leve
 
 // Print is a low-level API to print log.
 func (logger *Logger) Print(calldepth int, level Level, msg string) {
-	if logger.GetLevel() < level {
+	logger.print(calldepth+1, level, logger.prefix, msg)
+}
+
+func (logger *Logger) print(calldepth int, level Level, prefix, msg string) {
+	if logger.levelOf(prefix) < level || !logger.sampled(level, msg, time.Time{}) {
 		return
 	}
 	var (
@@ -460,88 +1326,339 @@ func (logger *Logger) Print(calldepth int, level Level, msg string) {
 		flags  = logger.GetFlags()
 	)
 	if flags&(Lshortfile|Llongfile) != 0 {
-		_, caller.Filename, caller.Line, _ = runtime.Caller(calldepth)
+		caller.PC, caller.Filename, caller.Line, _ = runtime.Caller(calldepth)
+	}
+	logger.provider.Print(level, flags, caller, prefix, msg)
+}
+
+// Err creates a context with level error and puts err for key "error"
+func (logger *Logger) Err(err error) *Context { return logger.Error().Error("error", err) }
+
+// WithPrefix creates a context logger with the prefix appended to the prefix of
+// logger, the prefixes are joined by "/".
+func (logger *Logger) WithPrefix(prefix string) *ContextLogger {
+	return &ContextLogger{
+		logger: logger,
+		prefix: joinPrefix(logger.prefix, prefix),
+	}
+}
+
+func joinPrefix(parent, prefix string) string {
+	if parent == "" {
+		return prefix
+	}
+	if prefix == "" {
+		return parent
+	}
+	return parent + "/" + prefix
+}
+
+// ContextLogger wraps a logger with a prefix and base fields, it shares
+// level, flags and provider with the logger.
+type ContextLogger struct {
+	logger *Logger
+	prefix string
+	fields []byte       // encoded base fields, immutable once created
+	keys   []encodedKey // recorded keys of base fields, see WithDedupeKeys
+}
+
+// Prefix returns the prefix of the context logger
+func (c *ContextLogger) Prefix() string { return c.prefix }
+
+// WithPrefix creates a context logger with the prefix appended to the prefix
+// of c, it's the same as Child.
+func (c *ContextLogger) WithPrefix(prefix string) *ContextLogger {
+	return c.Child(prefix)
+}
+
+// Child creates a context logger with the prefix appended to the prefix of c,
+// the child inherits base fields of c. Base fields are immutable, so they are
+// shared by the child rather than copied.
+func (c *ContextLogger) Child(prefix string) *ContextLogger {
+	return &ContextLogger{
+		logger: c.logger,
+		prefix: joinPrefix(c.prefix, prefix),
+		fields: c.fields,
+		keys:   c.keys,
+	}
+}
+
+// With creates a context logger with base fields of c and fields appended by
+// fn, base fields are put into every context created by the context logger,
+// e.g.
+//
+//	reqLogger := logger.WithPrefix("http").With(func(ctx *log.Context) {
+//		ctx.String("method", r.Method).String("path", r.URL.Path)
+//	})
+//	reqLogger.Info().Int("status", 200).Print("done") // {method:"GET",path:"/",status:200} done
+//
+// Fields are encoded once by With and copied into a new buffer, so c is not
+// affected. Every context created by the context logger copies them again.
+func (c *ContextLogger) With(fn func(ctx *Context)) *ContextLogger {
+	ctx := ctxPool.Get().(*Context)
+	ctx.reset(c.logger, LevelInfo, c.prefix)
+	ctx.encoder.appendFields(c.fields, c.keys)
+	fn(ctx)
+	ctx.encoder.settle()
+	var (
+		fields = make([]byte, len(ctx.encoder.buf))
+		keys   []encodedKey
+	)
+	copy(fields, ctx.encoder.buf)
+	if len(ctx.encoder.keys) > 0 {
+		keys = append(keys, ctx.encoder.keys...)
+	}
+	putContext(ctx)
+	return &ContextLogger{
+		logger: c.logger,
+		prefix: c.prefix,
+		fields: fields,
+		keys:   keys,
 	}
-	logger.provider.Print(level, flags, caller, logger.prefix, msg)
 }
 
-// default global logger
+// context creates a context with base fields
+func (c *ContextLogger) context(level Level) *Context {
+	ctx := getContext(c.logger, level, c.prefix)
+	if ctx != nil {
+		// fields may have been put by field funcs, see WithFieldFunc
+		ctx.encoder.appendFields(c.fields, c.keys)
+	}
+	return ctx
+}
+
+// If returns c if ok, otherwise returns an empty printer
+func (c *ContextLogger) If(ok bool) Printer {
+	if ok {
+		return c
+	}
+	return emptyPrinter{}
+}
+
+// Trace creates a context with level trace
+func (c *ContextLogger) Trace() *Context { return c.context(LevelTrace) }
+
+// Debug creates a context with level debug
+func (c *ContextLogger) Debug() *Context { return c.context(LevelDebug) }
+
+// Info creates a context with level info
+func (c *ContextLogger) Info() *Context { return c.context(LevelInfo) }
+
+// Warn creates a context with level warn
+func (c *ContextLogger) Warn() *Context { return c.context(LevelWarn) }
+
+// Error creates a context with level error
+func (c *ContextLogger) Error() *Context { return c.context(LevelError) }
+
+// Fatal creates a context with level fatal
+func (c *ContextLogger) Fatal() *Context { return c.context(LevelFatal) }
+
+// Log creates a context with specified level
+func (c *ContextLogger) Log(level Level) *Context { return c.context(level) }
+
+// Err creates a context with level error and puts err for key "error"
+func (c *ContextLogger) Err(err error) *Context { return c.Error().Error("error", err) }
+
+// Print is a low-level API to print log, base fields are printed like fields
+// of a context created by c.
+func (c *ContextLogger) Print(calldepth int, level Level, msg string) {
+	ctx := c.context(level)
+	if ctx == nil {
+		return
+	}
+	if !c.logger.sampled(level, msg, ctx.time) {
+		putContext(ctx)
+		return
+	}
+	ctx.encoder.finish()
+	ctx.encoder.writeString(msg)
+	ctx.output(calldepth + 1)
+}
+
+// DefaultLogger is the initial global logger. Assigning it still replaces the
+// logger used by package-level functions until the next SetDefault, but it's
+// racy if other goroutines are logging, use SetDefault instead.
 var DefaultLogger = func() *Logger {
 	var logger = NewLogger("")
 	logger.Start(WithSync(true), WithOutput(os.Stderr), WithLevel(LevelDebug))
 	return logger
 }()
 
+// defaultState is the global logger set by SetDefault
+type defaultState struct {
+	logger   *Logger
+	assigned *Logger // DefaultLogger when SetDefault was called
+}
+
+// defaultLogger holds the *defaultState of the global logger
+var defaultLogger = unsafe.Pointer(&defaultState{logger: DefaultLogger, assigned: DefaultLogger})
+
+// Default returns the global logger used by package-level functions, it's
+// DefaultLogger if it's assigned after the last SetDefault
+func Default() *Logger {
+	s := (*defaultState)(atomic.LoadPointer(&defaultLogger))
+	if logger := DefaultLogger; logger != s.assigned && logger != nil {
+		return logger
+	}
+	return s.logger
+}
+
+// SetDefault replaces the global logger used by package-level functions, it's
+// safe to be called while other goroutines are logging
+func SetDefault(logger *Logger) {
+	if logger == nil {
+		panic("log: SetDefault with a nil logger")
+	}
+	atomic.StorePointer(&defaultLogger, unsafe.Pointer(&defaultState{logger: logger, assigned: DefaultLogger}))
+}
+
+// defaultContext creates a context of the global logger
+func defaultContext(level Level) *Context {
+	logger := Default()
+	return getContext(logger, level, logger.prefix)
+}
+
 // Start starts the global logger
 func Start(options ...Option) error {
-	return DefaultLogger.Start(options...)
+	return Default().Start(options...)
+}
+
+// SetupFile starts the global logger with level, a console writer to stderr
+// and a file writer with sane defaults: logs are written to files in
+// dir/history which are rotated daily and every 64M, and dir/name.log is
+// symlinked to the current one. Files are appended rather than truncated.
+func SetupFile(dir, name string, level Level) error {
+	return Start(
+		WithLevel(level),
+		WithOutput(os.Stderr),
+		WithFile(FileOptions{
+			Dir:      dir,
+			Filename: name,
+			Symdir:   "history",
+			Rotate:   true,
+		}),
+	)
 }
 
 // Shutdown shutdowns the global logger
 func Shutdown() {
-	DefaultLogger.Shutdown()
+	Default().Shutdown()
 }
 
 // GetFlags returns the output flags
 func GetFlags() {
-	DefaultLogger.GetFlags()
+	Default().GetFlags()
 }
 
 // SetFlags sets the output flags
 func SetFlags(flags int) {
-	DefaultLogger.SetFlags(flags)
+	Default().SetFlags(flags)
 }
 
 // GetLevel returns the log level
 func GetLevel() Level {
-	return DefaultLogger.GetLevel()
+	return Default().GetLevel()
 }
 
 // SetLevel sets the log level
 func SetLevel(level Level) {
-	DefaultLogger.SetLevel(level)
+	Default().SetLevel(level)
 }
 
-// If returns the DefaultLogger if ok, otherwise returns nil
+// WatchLevelFile watches the level file for the global logger, see
+// Logger.WatchLevelFile
+func WatchLevelFile(path string, interval time.Duration) error {
+	return Default().WatchLevelFile(path, interval)
+}
+
+// WritersHealth returns the health of each writer of the global logger
+func WritersHealth() []error {
+	return Default().WritersHealth()
+}
+
+// SetWriter replaces writers of the global logger by w
+func SetWriter(w Writer) error {
+	return Default().SetWriter(w)
+}
+
+// SetOutput replaces writers of the global logger by a console writer with
+// specified io.Writer
+func SetOutput(w io.Writer) error {
+	return Default().SetOutput(w)
+}
+
+// If returns the global logger if ok, otherwise returns nil
 func If(ok bool) Printer {
 	if ok {
-		return DefaultLogger
+		return Default()
 	}
 	return emptyPrinter{}
 }
 
 // Trace creates a context with level trace
-func Trace() *Context { return getContext(DefaultLogger, LevelTrace, DefaultLogger.prefix) }
+func Trace() *Context { return defaultContext(LevelTrace) }
 
 // Debug creates a context with level debug
-func Debug() *Context { return getContext(DefaultLogger, LevelDebug, DefaultLogger.prefix) }
+func Debug() *Context { return defaultContext(LevelDebug) }
 
 // Info creates a context with level info
-func Info() *Context { return getContext(DefaultLogger, LevelInfo, DefaultLogger.prefix) }
+func Info() *Context { return defaultContext(LevelInfo) }
 
 // Warn creates a context with level warn
-func Warn() *Context { return getContext(DefaultLogger, LevelWarn, DefaultLogger.prefix) }
+func Warn() *Context { return defaultContext(LevelWarn) }
 
 // Error creates a context with level error
-func Error() *Context { return getContext(DefaultLogger, LevelError, DefaultLogger.prefix) }
+func Error() *Context { return defaultContext(LevelError) }
 
 // Fatal creates a context with level fatal
-func Fatal() *Context { return getContext(DefaultLogger, LevelFatal, DefaultLogger.prefix) }
+func Fatal() *Context { return defaultContext(LevelFatal) }
 
 // Log creates a context with specified level
-func Log(level Level) *Context { return getContext(DefaultLogger, level, DefaultLogger.prefix) }
+func Log(level Level) *Context { return defaultContext(level) }
+
+// Err creates a context with level error and puts err for key "error"
+func Err(err error) *Context { return Default().Err(err) }
+
+// WithPrefix creates a context logger of the global logger with the prefix
+func WithPrefix(prefix string) *ContextLogger { return Default().WithPrefix(prefix) }
 
 // Print is a low-level API to print log.
 func Print(calldepth int, level Level, msg string) {
-	if DefaultLogger.GetLevel() < level {
-		return
+	logger := Default()
+	logger.print(calldepth+1, level, logger.prefix, msg)
+}
+
+// Recover recovers a panic and logs it with the stack of the panicking
+// goroutine by the global logger, it must be deferred directly, e.g.
+//
+//	go func() {
+//		defer log.Recover()
+//		...
+//	}()
+//
+// The caller in header is the panicking statement. The recovered value is
+// panicked again if the logger started with WithRepanic.
+func Recover() {
+	if r := recover(); r != nil {
+		Default().recovered(r)
 	}
-	var (
-		caller Caller
-		flags  = DefaultLogger.GetFlags()
-	)
-	if flags&(Lshortfile|Llongfile) != 0 {
-		_, caller.Filename, caller.Line, _ = runtime.Caller(calldepth)
+}
+
+// RecoverWith is same as Recover but logs by the logger
+func RecoverWith(logger *Logger) {
+	if r := recover(); r != nil {
+		logger.recovered(r)
+	}
+}
+
+// recovered logs r with the panicking frame as the caller in header
+func (logger *Logger) recovered(r interface{}) {
+	frames := panicFrames(1)
+	logger.Error().
+		Any("panic", r).
+		Strings("stack", frames).
+		printAt(frameCaller(frames), "recovered from panic")
+	if logger.repanic {
+		panic(r)
 	}
-	DefaultLogger.provider.Print(level, flags, caller, DefaultLogger.prefix, msg)
 }
@@ -2,9 +2,18 @@ package log_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -29,6 +38,36 @@ func (w *testingLogWriter) Write(level log.Level, data []byte, headerLen int) er
 
 func (w *testingLogWriter) Close() error { return nil }
 
+// startTestLogger starts a synchronous logger with prefix which writes to
+// the returned writer, options are applied after the writer
+func startTestLogger(prefix string, options ...log.Option) (*log.Logger, *testingLogWriter) {
+	writer := new(testingLogWriter)
+	logger := log.NewLogger(prefix)
+	logger.Start(append([]log.Option{log.WithWriters(writer), log.WithSync(true)}, options...)...)
+	return logger, writer
+}
+
+// outputCase is a case of testOutputs: entries printed by print with a
+// logger started by startTestLogger("", options...) are want
+type outputCase struct {
+	name    string
+	options []log.Option
+	print   func(logger *log.Logger)
+	want    string
+}
+
+func testOutputs(t *testing.T, cases []outputCase) {
+	t.Helper()
+	for _, tc := range cases {
+		logger, writer := startTestLogger("", tc.options...)
+		tc.print(logger)
+		logger.Shutdown()
+		if got := writer.buf.String(); got != tc.want {
+			t.Errorf("%s: want %q, but got %q", tc.name, tc.want, got)
+		}
+	}
+}
+
 func TestWriter(t *testing.T) {
 	writer := new(testingLogWriter)
 	log.Start(log.WithWriters(writer), log.WithLevel(log.LevelTrace))
@@ -68,6 +107,8 @@ func ExampleLog() {
 	logger.Info().String("string", "hello").Print("ctx")
 	logger.Info().Error("error", nil).Print("ctx")
 	logger.Info().Error("error", errors.New("err")).Print("ctx")
+	logger.Info().RawJSON("raw", []byte(`{"a":"b"}`)).Print("ctx")
+	logger.Info().RawJSON("raw", nil).Print("ctx")
 	logger.Info().Any("any", nil).Print("ctx")
 	logger.Info().Any("any", "nil").Print("ctx")
 	logger.Info().Any("any", struct {
@@ -95,9 +136,18 @@ func ExampleLog() {
 	logger.Info().Int32s("int32s", []int32{1, 3, 5}).Print("ctx")
 	logger.Info().Strings("strings", []string{"x", "x y", "z"}).Print("ctx")
 	logger.Info().Bytes("bytes", []byte{'1', '3', 'x'}).Print("ctx")
+	users := []struct {
+		id   int
+		name string
+	}{{1, "x"}, {2, "y"}}
+	logger.Info().Strs("names", len(users), func(i int) string { return users[i].name }).Print("ctx")
+	logger.Info().IntsFunc("ids", len(users), func(i int) int { return users[i].id }).Print("ctx")
 	logger.Debug().String("key", "value").Print("not output")
 	logger.If(true).Info().String("key", "value").Print("should be printed")
 	logger.If(false).Info().String("key", "value").Print("should not be printed")
+	logger.Info().When(true, func(ctx *log.Context) { ctx.Int("x", 1) }).When(false, func(ctx *log.Context) { ctx.Int("y", 2) }).Print("when")
+	logger.Info().Map("map", map[string]interface{}{"b": 1, "a": "x", "c d": map[string]interface{}{"e": nil}}).Print("ctx")
+	logger.Info().StringMap("strings", map[string]string{"y": "2", "x": "1"}).Print("ctx")
 	logger.Shutdown()
 	fmt.Print(writer.buf.String())
 	// Output:
@@ -123,6 +173,8 @@ func ExampleLog() {
 	// [INFO] (testing) {string:"hello"} ctx
 	// [INFO] (testing) {error:nil} ctx
 	// [INFO] (testing) {error:"err"} ctx
+	// [INFO] (testing) {raw:{"a":"b"}} ctx
+	// [INFO] (testing) {raw:nil} ctx
 	// [INFO] (testing) {any:nil} ctx
 	// [INFO] (testing) {any:"nil"} ctx
 	// [INFO] (testing) {any:"{1 hello}"} ctx
@@ -137,7 +189,12 @@ func ExampleLog() {
 	// [INFO] (testing) {int32s:[1,3,5]} ctx
 	// [INFO] (testing) {strings:["x","x y","z"]} ctx
 	// [INFO] (testing) {bytes:0x313378} ctx
+	// [INFO] (testing) {names:["x","y"]} ctx
+	// [INFO] (testing) {ids:[1,2]} ctx
 	// [INFO] (testing) {key:"value"} should be printed
+	// [INFO] (testing) {x:1} when
+	// [INFO] (testing) {map:{a:"x",b:1,"c d":{e:nil}}} ctx
+	// [INFO] (testing) {strings:{x:"1",y:"2"}} ctx
 }
 
 func benchmarkSetup(b *testing.B, caller, off bool) {
@@ -186,60 +243,816 @@ func BenchmarkWithCaller(b *testing.B)    { benchmarkContext(b, true, false) }
 func BenchmarkWithoutCaller(b *testing.B) { benchmarkContext(b, false, false) }
 func BenchmarkOff(b *testing.B)           { benchmarkContext(b, true, true) }
 
-// testFS implements File interface
-type testFile struct {
-	content bytes.Buffer
+func benchmarkLargeEntry(b *testing.B, poolMaxSize int) {
+	logger, writer := startTestLogger("", log.WithBufferPoolMaxSize(poolMaxSize))
+	writer.discard = true
+	value := string(bytes.Repeat([]byte{'x'}, 2*log.KB))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Info().String("value", value).Print("large entry")
+	}
+	b.StopTimer()
+	logger.Shutdown()
 }
 
-func (t *testFile) Write(p []byte) (int, error) { return t.content.Write(p) }
-func (t *testFile) Close() error                { return nil }
-func (t *testFile) Sync() error                 { return nil }
+func BenchmarkLargeEntry(b *testing.B)         { benchmarkLargeEntry(b, 0) }
+func BenchmarkLargeEntryRetained(b *testing.B) { benchmarkLargeEntry(b, 8*log.KB) }
 
-// testFS implements FS interface
-type testFS struct {
-	files map[string]*testFile
+func benchmarkHeader(b *testing.B, noHeader bool) {
+	logger, writer := startTestLogger("", log.WithFlags(0), log.WithNoHeader(noHeader))
+	writer.discard = true
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Info().Int("i", i).Print("header")
+	}
+	b.StopTimer()
+	logger.Shutdown()
 }
 
-func newTestFS() *testFS {
-	return &testFS{
-		files: make(map[string]*testFile),
+func BenchmarkHeader(b *testing.B)   { benchmarkHeader(b, false) }
+func BenchmarkNoHeader(b *testing.B) { benchmarkHeader(b, true) }
+
+// benchmarkPoolWarmup logs a burst of concurrent contexts right after pools
+// are emptied by the garbage collector
+func benchmarkPoolWarmup(b *testing.B, warmup int) {
+	const burst = 16
+	writer := new(testingLogWriter)
+	writer.discard = true
+	logger := log.NewLogger("")
+	var ctxs [burst]*log.Context
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		runtime.GC()
+		runtime.GC()
+		logger.Start(log.WithWriters(writer), log.WithSync(true), log.WithEncoderPoolWarmup(warmup))
+		b.StartTimer()
+		for j := range ctxs {
+			ctxs[j] = logger.Info().Int("j", j).String("k", "v")
+		}
+		for j := range ctxs {
+			ctxs[j].Print("burst")
+		}
 	}
+	b.StopTimer()
+	logger.Shutdown()
 }
 
-// OpenFile implements FS OpenFile method
-func (fs testFS) OpenFile(name string, flag int, perm os.FileMode) (log.File, error) {
-	f, ok := fs.files[name]
-	if ok {
-		if flag&os.O_CREATE != 0 && flag&os.O_EXCL == 0 {
-			return nil, os.ErrExist
+func BenchmarkPoolCold(b *testing.B) { benchmarkPoolWarmup(b, 0) }
+func BenchmarkPoolWarm(b *testing.B) { benchmarkPoolWarmup(b, 16) }
+
+func BenchmarkDict(b *testing.B) {
+	logger, writer := startTestLogger("")
+	writer.discard = true
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Info().Dict("user", func(ctx *log.Context) {
+			ctx.Int("id", i).Dict("profile", func(ctx *log.Context) {
+				ctx.String("name", "hello")
+			})
+		}).Print("nested")
+	}
+	b.StopTimer()
+	logger.Shutdown()
+}
+
+func BenchmarkDiscard(b *testing.B) {
+	logger, writer := startTestLogger("")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Info().Int("i", i).String("string", "hello").Discard()
+	}
+	b.StopTimer()
+	logger.Shutdown()
+	if writer.buf.Len() > 0 {
+		b.Fatalf("discarded context printed: %q", writer.buf.String())
+	}
+}
+
+func BenchmarkParallel(b *testing.B) {
+	writer := new(testingLogWriter)
+	writer.discard = true
+	logger := log.NewLogger("")
+	logger.Start(log.WithWriters(writer))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			logger.Info().Int("int", 123456).String("string", "hello").Print("parallel")
 		}
-		if flag&os.O_TRUNC != 0 {
-			f.content.Reset()
+	})
+	b.StopTimer()
+	logger.Shutdown()
+}
+
+// testClock is a manually advanced clock
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func TestWatchLevelFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "level")
+	if err := ioutil.WriteFile(path, []byte("debug\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 16)
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithWriters(&testingLogWriter{discard: true}),
+		log.WithErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}),
+	)
+	defer logger.Shutdown()
+	if err := logger.WatchLevelFile(path, 0); err == nil {
+		t.Error("want an error for a non-positive interval")
+	}
+	if err := logger.WatchLevelFile(path, 10*time.Millisecond); err != nil {
+		t.Fatalf("watch level file: %v", err)
+	}
+	if got := logger.GetLevel(); got != log.LevelDebug {
+		t.Fatalf("want level debug, but got %v", got)
+	}
+	if err := writeFileAtomic(path, "WARN"); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); logger.GetLevel() != log.LevelWarn; {
+		if time.Now().After(deadline) {
+			t.Fatalf("want level warn, but got %v", logger.GetLevel())
 		}
-	} else if flag&os.O_CREATE != 0 {
-		f = &testFile{}
-		fs.files[name] = f
-	} else {
-		return nil, os.ErrNotExist
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := writeFileAtomic(path, "loud"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if want := `log: invalid level "loud" in ` + path; err.Error() != want {
+			t.Errorf("want error %q, but got %q", want, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("error not handled")
+	}
+	if err := logger.WatchLevelFile(filepath.Join(dir, "missing"), time.Hour); err == nil {
+		t.Error("want an error for missing file")
+	}
+	if got := logger.GetLevel(); got != log.LevelWarn {
+		t.Errorf("want level warn kept, but got %v", got)
+	}
+}
+
+// writeFileAtomic replaces the file by renaming, so the polling watcher never
+// reads a truncated file
+func writeFileAtomic(path, content string) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(content), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func TestWatchLevelFileConcurrentShutdown(t *testing.T) {
+	logger := log.NewLogger("")
+	logger.Start(log.WithWriters(&testingLogWriter{discard: true}), log.WithErrorHandler(func(error) {}))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			logger.WatchLevelFile("missing", time.Hour)
+		}()
+		go func() {
+			defer wg.Done()
+			logger.Shutdown()
+		}()
+	}
+	wg.Wait()
+	logger.Shutdown()
+}
+
+func TestContextLogger(t *testing.T) {
+	logger, writer := startTestLogger("app")
+	db := logger.WithPrefix("db")
+	db.Info().Print("hello")
+	db.WithPrefix("sql").Err(errors.New("failed")).Print("query")
+	db.Print(1, log.LevelWarn, "low-level")
+	db.Debug().Print("not output")
+	req := db.With(func(ctx *log.Context) { ctx.Int("id", 1) })
+	req.Child("tx").With(func(ctx *log.Context) { ctx.String("tx", "a") }).Info().Bool("ok", true).Print("commit")
+	req.Print(1, log.LevelInfo, "done")
+	db.Info().Print("parent")
+	logger.Shutdown()
+	got := writer.buf.String()
+	want := "[INFO] (app/db) hello\n" +
+		"[ERROR] (app/db/sql) {error:\"failed\"} query\n" +
+		"[WARN] (app/db) low-level\n" +
+		"[INFO] (app/db/tx) {id:1,tx:\"a\",ok:true} commit\n" +
+		"[INFO] (app/db) {id:1} done\n" +
+		"[INFO] (app/db) parent\n"
+	if got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+}
+
+func TestContextLoggerPrint(t *testing.T) {
+	fieldFunc := log.WithFieldFunc(func(ctx *log.Context) { ctx.String("f", "func") })
+	logger, writer := startTestLogger("", log.WithFieldsPosition(log.FieldsAfter), fieldFunc)
+	logger.WithPrefix("db").With(func(ctx *log.Context) { ctx.Int("id", 1) }).Print(1, log.LevelInfo, "after")
+	logger.Shutdown()
+	if want := "[INFO] (db) after {f:\"func\",id:1}\n"; writer.buf.String() != want {
+		t.Errorf("want %q, but got %q", want, writer.buf.String())
+	}
+
+	var (
+		structured = new(recordWriter)
+		line       int
+	)
+	logger = log.NewLogger("")
+	logger.Start(log.WithWriters(structured), log.WithSync(true), log.WithFlags(log.Lshortfile), fieldFunc)
+	_, _, line, _ = runtime.Caller(0)
+	logger.WithPrefix("db").With(func(ctx *log.Context) { ctx.Int("id", 1) }).Print(1, log.LevelInfo, "done")
+	logger.Shutdown()
+	if len(structured.records) != 1 {
+		t.Fatalf("want 1 record, but got %d", len(structured.records))
 	}
-	return f, nil
+	r := structured.records[0]
+	if r.Prefix != "db" || r.Message != "done" || r.RawFields != `{f:"func",id:1}` ||
+		r.Caller.Filename != "log_test.go" || r.Caller.Line != line+1 {
+		t.Errorf("unexpected record %+v", r)
+	}
+}
+
+func TestLevelJSON(t *testing.T) {
+	for level := log.LevelFatal; level <= log.LevelTrace; level++ {
+		data, err := json.Marshal(level)
+		if err != nil {
+			t.Fatalf("marshal %v: %v", level, err)
+		}
+		if want := `"` + level.String() + `"`; string(data) != want {
+			t.Errorf("want %s, but got %s", want, data)
+		}
+		var got log.Level
+		if err := json.Unmarshal(data, &got); err != nil || got != level {
+			t.Errorf("round trip %s: got %v, %v", data, got, err)
+		}
+		got = 0
+		if err := json.Unmarshal([]byte(level.Literal()), &got); err != nil || got != level {
+			t.Errorf("unmarshal number %s: got %v, %v", level.Literal(), got, err)
+		}
+	}
+	for _, data := range []string{`0`, `7`, `10`, `-1`, `1.5`, `"unknown"`, `"0"`, `true`} {
+		got := log.LevelDebug
+		if err := json.Unmarshal([]byte(data), &got); err == nil {
+			t.Errorf("unmarshal %s: want an error", data)
+		}
+		if got != log.LevelDebug {
+			t.Errorf("unmarshal %s: want level unchanged, but got %v", data, got)
+		}
+	}
+	got := log.LevelDebug
+	if err := json.Unmarshal([]byte(`null`), &got); err != nil || got != log.LevelDebug {
+		t.Errorf("unmarshal null: got %v, %v", got, err)
+	}
+}
+
+func TestLevelFormat(t *testing.T) {
+	for _, short := range []bool{true, false} {
+		var buf bytes.Buffer
+		logger := log.NewLogger("")
+		logger.Start(
+			log.WithOutput(&buf),
+			log.WithSync(true),
+			log.WithFlags(log.Lshortfile),
+			log.WithLevelFormat(short),
+		)
+		logger.Warn().Print("warn")
+		logger.SetFlags(0)
+		logger.Info().Print("info")
+		logger.Shutdown()
+		warn, info := "[W log_test.go:", "[I] info\n"
+		if !short {
+			warn, info = "[WARN log_test.go:", "[INFO] info\n"
+		}
+		if got := buf.String(); !strings.HasPrefix(got, warn) || !strings.HasSuffix(got, "] warn\n"+info) {
+			t.Errorf("short %v: unexpected output %q", short, got)
+		}
+	}
+}
+
+// panicking panics and recovers by logger, the caller of the panicking
+// statement is returned
+func panicking(logger *log.Logger, nilDeref bool) (file string, line int) {
+	defer log.RecoverWith(logger)
+	if nilDeref {
+		var p *int
+		_, file, line, _ = runtime.Caller(0)
+		_ = *p // line+1
+	}
+	_, file, line, _ = runtime.Caller(0)
+	panic("boom") // line+1
 }
 
-// Remove implements FS Remove method
-func (fs *testFS) Remove(name string) error {
-	if _, ok := fs.files[name]; !ok {
-		return os.ErrNotExist
+func TestRecover(t *testing.T) {
+	var buf bytes.Buffer
+	logger, writer := startTestLogger("", log.WithOutput(&buf), log.WithFlags(log.Lshortfile))
+	file1, line1 := panicking(logger, false)
+	file2, line2 := panicking(logger, true)
+	lines := strings.Split(strings.TrimSuffix(writer.buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 lines, but got %q", writer.buf.String())
+	}
+	for i, want := range []string{`panic:"boom"`, `panic:"runtime error: invalid memory address`} {
+		if !strings.HasPrefix(lines[i], "[ERROR] {"+want) ||
+			!strings.Contains(lines[i], `,stack:["github.com/gopherd/log_test.panicking `) ||
+			!strings.HasSuffix(lines[i], "} recovered from panic") {
+			t.Errorf("unexpected output %q", lines[i])
+		}
 	}
-	delete(fs.files, name)
+	headers := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for i, want := range []string{
+		fmt.Sprintf("[E %s:%d] ", filepath.Base(file1), line1+1),
+		fmt.Sprintf("[E %s:%d] ", filepath.Base(file2), line2+1),
+	} {
+		if i >= len(headers) || !strings.HasPrefix(headers[i], want) {
+			t.Errorf("want caller %q, but got %q", want, buf.String())
+		}
+	}
+
+	logger.Start(log.WithWriters(writer), log.WithSync(true), log.WithRepanic(true))
+	defer logger.Shutdown()
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("want repanic with %q, but got %v", "boom", r)
+		}
+	}()
+	panicking(logger, false)
+}
+
+func TestNoHeader(t *testing.T) {
+	var buf bytes.Buffer
+	logger, writer := startTestLogger("", log.WithOutput(&buf), log.WithNoHeader(true))
+	logger.Info().Int("id", 1).Print("context")
+	logger.WithPrefix("http").Warn().Print("prefixed")
+	logger.Shutdown()
+	want := `[INFO] {id:1} context
+[WARN] (http) prefixed
+`
+	if got := writer.buf.String(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+	want = `{id:1} context
+(http) prefixed
+`
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+}
+
+type traceKey struct{}
+
+func TestTraceContext(t *testing.T) {
+	logger, writer := startTestLogger("")
+	c := context.WithValue(context.Background(), traceKey{}, "t1")
+	logger.Info().TraceID("t0").SpanID("s0").Print("explicit")
+	logger.Info().FromTraceContext(c).Print("no extractor")
+	logger.Shutdown()
+	logger.Start(log.WithWriters(writer), log.WithSync(true), log.WithTraceExtractor(func(c context.Context) (string, string) {
+		id, _ := c.Value(traceKey{}).(string)
+		return id, ""
+	}))
+	logger.Info().FromTraceContext(c).Print("extracted")
+	logger.Info().FromTraceContext(context.Background()).Print("no trace")
+	logger.Shutdown()
+	want := `[INFO] {trace_id:"t0",span_id:"s0"} explicit
+[INFO] no extractor
+[INFO] {trace_id:"t1"} extracted
+[INFO] no trace
+`
+	if got := writer.buf.String(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	logger, writer := startTestLogger("")
+	defer logger.Shutdown()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			log.Trace().Int("i", i).Print("concurrent")
+		}
+	}()
+	log.SetDefault(logger)
+	wg.Wait()
+	log.Info().Print("swapped")
+	log.WithPrefix("p").Info().Print("prefixed")
+	log.SetDefault(log.DefaultLogger)
+	log.Trace().Print("restored")
+
+	if log.Default() != log.DefaultLogger {
+		t.Error("default logger not restored")
+	}
+
+	// assigning DefaultLogger is honored until the next SetDefault
+	initial := log.DefaultLogger
+	log.DefaultLogger = logger
+	log.Info().Print("assigned")
+	otherLogger, other := startTestLogger("")
+	defer otherLogger.Shutdown()
+	log.SetDefault(otherLogger)
+	log.Info().Print("set")
+	log.DefaultLogger = initial
+	log.SetDefault(initial)
+	if log.Default() != initial {
+		t.Error("default logger not restored")
+	}
+	if want := "[INFO] set\n"; other.buf.String() != want {
+		t.Errorf("want %q, but got %q", want, other.buf.String())
+	}
+	want := "[INFO] swapped\n[INFO] (p) prefixed\n[INFO] assigned\n"
+	if got := writer.buf.String(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+}
+
+func TestClone(t *testing.T) {
+	logger, writer := startTestLogger("", log.WithLevel(log.LevelInfo))
+	clone := logger.Clone("sub")
+	clone.SetLevel(log.LevelDebug)
+	clone.SetFlags(log.Lshortfile)
+	if logger.GetLevel() != log.LevelInfo || logger.GetFlags() == log.Lshortfile {
+		t.Error("the logger is affected by the clone")
+	}
+	if err := clone.Start(); err == nil {
+		t.Error("want an error starting the clone")
+	}
+	logger.Debug().Print("dropped")
+	clone.Debug().Print("debug")
+	logger.Shutdown()
+	clone.Info().Print("after shutdown")
+	if got, want := writer.buf.String(), "[DEBUG] (sub) debug\n"; got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+}
+
+func TestCloneSetWriter(t *testing.T) {
+	logger, _ := startTestLogger("")
+	defer logger.Shutdown()
+	var (
+		wg     sync.WaitGroup
+		clones = make(chan *log.Logger, 100)
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < cap(clones); i++ {
+			clones <- logger.Clone("sub")
+		}
+		close(clones)
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			logger.SetWriter(&testingLogWriter{discard: true})
+		}
+	}()
+	wg.Wait()
+	errDown := errors.New("down")
+	if err := logger.SetWriter(&healthWriter{err: errDown}); err != nil {
+		t.Fatalf("set writer error: %v", err)
+	}
+	for clone := range clones {
+		if got := clone.WritersHealth(); len(got) != 1 || got[0] != errDown {
+			t.Fatalf("want health of the writer set to the logger, but got %v", got)
+		}
+	}
+}
+
+func TestFieldFunc(t *testing.T) {
+	var calls int
+	logger, writer := startTestLogger("",
+		log.WithFieldFunc(func(ctx *log.Context) {
+			calls++
+			ctx.Int("seq", calls)
+		}),
+		log.WithFieldFunc(func(ctx *log.Context) {
+			ctx.String("trace_id", "abc")
+		}),
+	)
+	logger.Info().String("k", "v").Print("first")
+	logger.Debug().Print("filtered")
+	logger.WithPrefix("sub").With(func(ctx *log.Context) {
+		ctx.Bool("base", true)
+	}).Info().Print("second")
+	logger.Shutdown()
+	want := `[INFO] {seq:1,trace_id:"abc",k:"v"} first
+[INFO] (sub) {seq:2,trace_id:"abc",base:true} second
+`
+	if got := writer.buf.String(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+	if calls != 2 {
+		t.Errorf("want 2 calls, but got %d", calls)
+	}
+}
+
+func TestLevelText(t *testing.T) {
+	type config struct {
+		Level log.Level `xml:"level"`
+		Attr  log.Level `xml:"attr,attr"`
+	}
+	var cfg config
+	if err := xml.Unmarshal([]byte(`<config attr="warn"><level>debug</level></config>`), &cfg); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	if cfg.Level != log.LevelDebug || cfg.Attr != log.LevelWarn {
+		t.Errorf("want debug and warn, but got %v and %v", cfg.Level, cfg.Attr)
+	}
+	data, err := xml.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+	if want := `<config attr="WARN"><level>DEBUG</level></config>`; string(data) != want {
+		t.Errorf("want %s, but got %s", want, data)
+	}
+	if err := xml.Unmarshal([]byte(`<config><level>unknown</level></config>`), &cfg); err == nil {
+		t.Error("want an error for unknown level")
+	}
+	if cfg.Level != log.LevelDebug {
+		t.Errorf("want level unchanged, but got %v", cfg.Level)
+	}
+	// levels as keys of JSON objects are encoded by text methods
+	m := map[log.Level]int{log.LevelInfo: 1}
+	if data, err := json.Marshal(m); err != nil || string(data) != `{"INFO":1}` {
+		t.Errorf("marshal map: %s, %v", data, err)
+	}
+}
+
+func TestCaller(t *testing.T) {
+	logger, writer := startTestLogger("")
+	logger.Info().Caller("caller", 0).Caller("none", 100).Print("short")
+	logger.SetFlags(log.Llongfile)
+	logger.Info().Caller("caller", 0).Print("long")
+	logger.Shutdown()
+	_, file, _, _ := runtime.Caller(0)
+	lines := strings.Split(writer.buf.String(), "\n")
+	if len(lines) != 3 ||
+		!strings.HasPrefix(lines[0], `[INFO] {caller:"log_test.go:`) ||
+		!strings.HasSuffix(lines[0], `",none:"???:0"} short`) ||
+		!strings.HasPrefix(lines[1], `[INFO] {caller:"`+file+":") {
+		t.Errorf("unexpected output %q", writer.buf.String())
+	}
+}
+
+func TestTrimPath(t *testing.T) {
+	_, file, _, _ := runtime.Caller(0)
+	var (
+		dir  = path.Dir(file)
+		want = path.Base(dir) + "/" + path.Base(file) + ":"
+		buf  bytes.Buffer
+	)
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithOutput(&buf),
+		log.WithSync(true),
+		log.WithFlags(log.Llongfile),
+		log.WithTrimPath("/nonexistent", path.Dir(dir)+"/", path.Dir(path.Dir(dir))),
+	)
+	logger.Info().Caller("caller", 0).Print("trimmed")
+	logger.Shutdown()
+	if got := buf.String(); !strings.HasPrefix(got, "[I "+want) || !strings.Contains(got, `{caller:"`+want) {
+		t.Errorf("want caller %q, but got %q", want, got)
+	}
+}
+
+func TestLevelRules(t *testing.T) {
+	logger, writer := startTestLogger("",
+		log.WithFlags(0),
+		log.WithLevel(log.LevelInfo),
+		log.WithLevelRules(map[string]log.Level{
+			"db":      log.LevelWarn,
+			"db/slow": log.LevelDebug,
+			"auth":    log.LevelDebug,
+		}),
+	)
+	logger.Info().Print("root info")
+	logger.Debug().Print("root debug")
+	logger.WithPrefix("db").Info().Print("db info")
+	logger.WithPrefix("db").Warn().Print("db warn")
+	logger.WithPrefix("db").Child("pool").Info().Print("db/pool info")
+	logger.WithPrefix("db").Child("slow").Debug().Print("db/slow debug")
+	logger.WithPrefix("dbx").Info().Print("dbx info")
+	logger.WithPrefix("auth").Debug().Print("auth debug")
+	logger.Clone("auth").Debugf("clone %s", "debug")
+	logger.Shutdown()
+	want := "[INFO] root info\n" +
+		"[WARN] (db) db warn\n" +
+		"[DEBUG] (db/slow) db/slow debug\n" +
+		"[INFO] (dbx) dbx info\n" +
+		"[DEBUG] (auth) auth debug\n" +
+		"[DEBUG] (auth) clone debug\n"
+	if got := writer.buf.String(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+}
+
+func TestClockFunc(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithOutput(&buf),
+		log.WithSync(true),
+		log.WithFlags(log.Ltimestamp|log.LUTC|log.Lmicroseconds),
+		log.WithClock(log.ClockFunc(func() time.Time {
+			return time.Date(2001, 2, 3, 4, 5, 6, 7000, time.UTC)
+		})),
+	)
+	logger.Info().Print("fixed")
+	logger.Shutdown()
+	if want := "[I 2001/02/03 04:05:06.000007] fixed\n"; buf.String() != want {
+		t.Errorf("want %q, but got %q", want, buf.String())
+	}
+}
+
+// closedWriter panics if it's written after closed
+type closedWriter struct {
+	testingLogWriter
+	closed bool
+}
+
+func (w *closedWriter) Write(level log.Level, data []byte, headerLen int) error {
+	if w.closed {
+		panic("write after close")
+	}
+	return w.testingLogWriter.Write(level, data, headerLen)
+}
+
+func (w *closedWriter) Close() error {
+	w.closed = true
 	return nil
 }
 
-// Symlink implements FS Symlink method
-func (fs testFS) Symlink(oldname, newname string) error { return nil }
+type failingWriter struct {
+	testingLogWriter
+	err error
+}
+
+func (w *failingWriter) Write(level log.Level, data []byte, headerLen int) error {
+	return w.err
+}
+
+type healthWriter struct {
+	testingLogWriter
+	err error
+}
+
+func (w *healthWriter) Healthy() error { return w.err }
+
+func TestWritersConcurrently(t *testing.T) {
+	logger, writer := startTestLogger("")
+	writer.discard = true
+	defer logger.Shutdown()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			logger.SetWriter(&testingLogWriter{discard: true})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			logger.Rotate()
+			logger.Reopen()
+			logger.WritersHealth()
+		}
+	}()
+	wg.Wait()
+}
+
+func TestWritersHealth(t *testing.T) {
+	var (
+		errDown = errors.New("down")
+		health  = &healthWriter{err: errDown}
+		failing = &failingWriter{err: errDown}
+		async   = log.NewAsyncWriter(failing, 1)
+		logger  = log.NewLogger("")
+	)
+	logger.Start(log.WithWriters(new(testingLogWriter), health, async), log.WithSync(true))
+	logger.Info().Print("hello")
+	logger.Shutdown()
+	want := []error{nil, errDown, errors.New("log: async writer closed")}
+	got := logger.WritersHealth()
+	if len(got) != len(want) {
+		t.Fatalf("want %v, but got %v", want, got)
+	}
+	for i := range want {
+		if fmt.Sprint(got[i]) != fmt.Sprint(want[i]) {
+			t.Errorf("writer %d: want %v, but got %v", i, want[i], got[i])
+		}
+	}
+
+	// the async writer reports the error of the last write
+	async = log.NewAsyncWriter(failing, 1)
+	async.Write(log.LevelInfo, []byte("hello\n"), 0)
+	for i := 0; i < 100 && async.Healthy() == nil; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if err := async.Healthy(); err != errDown {
+		t.Errorf("want %v, but got %v", errDown, err)
+	}
+	async.Close()
+}
+
+func TestSetWriter(t *testing.T) {
+	for _, synced := range []bool{true, false} {
+		var (
+			old    = new(closedWriter)
+			writer = new(testingLogWriter)
+			logger = log.NewLogger("")
+		)
+		logger.Start(log.WithWriters(old), log.WithSync(synced), log.WithLevel(log.LevelInfo))
+		logger.Info().Print("before")
+		if err := logger.SetWriter(writer); err != nil {
+			t.Fatalf("sync %v: set writer error: %v", synced, err)
+		}
+		if !old.closed {
+			t.Errorf("sync %v: old writer not closed", synced)
+		}
+		if got, want := old.buf.String(), "[INFO] before\n"; got != want {
+			t.Errorf("sync %v: want %q in old writer, but got %q", synced, want, got)
+		}
 
-// MkdirAll implements FS MkdirAll method
-func (fs testFS) MkdirAll(path string, perm os.FileMode) error { return nil }
+		// prints concurrently while swapping writers
+		var (
+			wg      sync.WaitGroup
+			writers = []*testingLogWriter{writer, new(testingLogWriter), new(testingLogWriter)}
+		)
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					logger.Info().Print("x")
+				}
+			}()
+		}
+		for _, w := range writers[1:] {
+			if err := logger.SetWriter(w); err != nil {
+				t.Errorf("sync %v: set writer error: %v", synced, err)
+			}
+		}
+		wg.Wait()
+		logger.Shutdown()
+		var lines int
+		for _, w := range writers {
+			lines += strings.Count(w.buf.String(), "\n")
+		}
+		if lines != 400 {
+			t.Errorf("sync %v: want 400 lines, but got %d", synced, lines)
+		}
+		if err := logger.SetWriter(new(testingLogWriter)); err == nil {
+			t.Errorf("sync %v: want an error after shutdown", synced)
+		}
+	}
+}
 
-func TestFile(t *testing.T) {
-	// (TODO): test writer `file`
+func TestSetupFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "setup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := log.SetupFile(dir, "app", log.LevelInfo); err != nil {
+		t.Fatal(err)
+	}
+	defer log.Start(log.WithSync(true), log.WithOutput(os.Stderr), log.WithLevel(log.LevelDebug))
+	if log.GetLevel() != log.LevelInfo {
+		t.Errorf("want level info, but got %v", log.GetLevel())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app.log")); err != nil {
+		t.Errorf("symlink not found: %v", err)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, "history"))
+	if err != nil || len(files) != 1 {
+		t.Errorf("want one log file, but got %d files: %v", len(files), err)
+	}
 }
@@ -0,0 +1,143 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// defaultMemoryCapacity is the capacity of memory writers opened by
+// Open("memory") without a source
+const defaultMemoryCapacity = 1024
+
+// MemoryWriter is a writer which keeps the most recent entries in memory and
+// serves them over HTTP as a live log viewer, e.g.
+//
+//	mem := log.NewMemoryWriter(1024)
+//	log.Start(log.WithFile(options), log.WithWriters(mem))
+//	http.Handle("/debug/logs", mem) // or /debug/logs?format=json
+//
+// It's also opened by Open("memory:capacity"), the capacity is 1024 if the
+// source is empty.
+type MemoryWriter struct {
+	mu      sync.Mutex
+	entries []memoryEntry
+	next    int
+	full    bool
+}
+
+type memoryEntry struct {
+	level  Level
+	data   []byte
+	header int
+}
+
+// NewMemoryWriter creates a memory writer which holds at most capacity entries
+func NewMemoryWriter(capacity int) *MemoryWriter {
+	if capacity <= 0 {
+		panic("log: memory writer capacity must be positive")
+	}
+	return &MemoryWriter{
+		entries: make([]memoryEntry, capacity),
+	}
+}
+
+func openMemory(source string) (Writer, error) {
+	if source == "" {
+		return NewMemoryWriter(defaultMemoryCapacity), nil
+	}
+	capacity, err := strconv.Atoi(source)
+	if err != nil || capacity <= 0 {
+		return nil, errors.New("log: invalid source for memory: " + source)
+	}
+	return NewMemoryWriter(capacity), nil
+}
+
+// Write implements Writer Write method
+func (w *MemoryWriter) Write(level Level, data []byte, headerLen int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	e := &w.entries[w.next]
+	e.level = level
+	e.data = append(e.data[:0], data...)
+	e.header = headerLen
+	w.next++
+	if w.next == len(w.entries) {
+		w.next = 0
+		w.full = true
+	}
+	return nil
+}
+
+// Close implements Writer Close method, entries are kept after closed
+func (w *MemoryWriter) Close() error { return nil }
+
+// MemoryEntry represents an entry served by MemoryWriter in JSON format
+type MemoryEntry struct {
+	Level  string `json:"level"`
+	Header string `json:"header"`
+	Body   string `json:"body"`
+}
+
+// Entries returns all entries held by the memory writer from oldest to newest
+func (w *MemoryWriter) Entries() []MemoryEntry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var entries []MemoryEntry
+	if w.full {
+		entries = appendMemoryEntries(entries, w.entries[w.next:])
+	}
+	return appendMemoryEntries(entries, w.entries[:w.next])
+}
+
+func appendMemoryEntries(dst []MemoryEntry, entries []memoryEntry) []MemoryEntry {
+	for i := range entries {
+		e := &entries[i]
+		header := e.header
+		if header > len(e.data) {
+			header = len(e.data)
+		}
+		body := e.data[header:]
+		if n := len(body); n > 0 && body[n-1] == '\n' {
+			body = body[:n-1]
+		}
+		dst = append(dst, MemoryEntry{
+			Level:  e.level.String(),
+			Header: string(e.data[:header]),
+			Body:   string(body),
+		})
+	}
+	return dst
+}
+
+// ServeHTTP implements http.Handler ServeHTTP method, entries are rendered
+// as an HTML page styled like files with HTMLHeader, or as a JSON array of
+// MemoryEntry if the query has format=json.
+func (w *MemoryWriter) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	entries := w.Entries()
+	if r.URL.Query().Get("format") == "json" {
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if entries == nil {
+			entries = []MemoryEntry{}
+		}
+		json.NewEncoder(rw).Encode(entries)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	content, _ := lookupFileHeader(HTMLHeader)
+	io.WriteString(rw, "<!DOCTYPE html>\n<html>")
+	io.WriteString(rw, content)
+	io.WriteString(rw, "\n<body>\n")
+	for _, e := range entries {
+		io.WriteString(rw, `<div class="item"><span class="metadata">`)
+		io.WriteString(rw, html.EscapeString(e.Header))
+		io.WriteString(rw, `</span><pre class="content">`)
+		io.WriteString(rw, html.EscapeString(e.Body))
+		io.WriteString(rw, "</pre></div>\n")
+	}
+	io.WriteString(rw, "</body>\n</html>\n")
+}
@@ -0,0 +1,50 @@
+package log_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gopherd/log"
+)
+
+func TestMemoryWriter(t *testing.T) {
+	w, err := log.Open("memory:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.NewLogger("")
+	logger.Start(log.WithWriters(w), log.WithSync(true), log.WithFlags(0))
+	logger.Info().Print("first")
+	logger.Warn().Print("<b>")
+	logger.Error().Int("n", 1).Print("last")
+	logger.Shutdown()
+
+	rec := httptest.NewRecorder()
+	w.(*log.MemoryWriter).ServeHTTP(rec, httptest.NewRequest("GET", "/?format=json", nil))
+	var entries []log.MemoryEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("unmarshal %q: %v", rec.Body.String(), err)
+	}
+	want := []log.MemoryEntry{
+		{Level: "WARN", Header: "[W] ", Body: "<b>"},
+		{Level: "ERROR", Header: "[E] ", Body: "{n:1} last"},
+	}
+	if fmt.Sprint(entries) != fmt.Sprint(want) {
+		t.Errorf("want %v, but got %v", want, entries)
+	}
+
+	rec = httptest.NewRecorder()
+	w.(*log.MemoryWriter).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	body := rec.Body.String()
+	for _, s := range []string{"<style>", `<span class="metadata">[W] </span><pre class="content">&lt;b&gt;</pre>`} {
+		if !strings.Contains(body, s) {
+			t.Errorf("want %q in %q", s, body)
+		}
+	}
+	if _, err := log.Open("memory:0"); err == nil {
+		t.Error("want an error for invalid capacity")
+	}
+}
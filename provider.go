@@ -1,13 +1,17 @@
 package log
 
 import (
+	"bytes"
 	"errors"
+	"io"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 // Provider represents the provider for logging
@@ -42,38 +46,183 @@ func stack(calldepth int) []byte {
 	return e[startIndex:nbytes]
 }
 
+// GoID returns the id of the current goroutine which is parsed from the
+// first line of runtime.Stack, e.g. "goroutine 42 [running]:". It costs about
+// a microsecond, so it's recommended for debugging only.
+func GoID() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
+
+// stackFrames returns the call stack as frames formatted like "function file:line"
+func stackFrames(calldepth int) []string {
+	return parseFrames(stack(calldepth + 2))
+}
+
+// parseFrames parses the call stack returned by stack as frames
+func parseFrames(b []byte) []string {
+	var (
+		lines  = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+		frames = make([]string, 0, len(lines)/2)
+	)
+	for i := 0; i+1 < len(lines); i += 2 {
+		var (
+			fn   = lines[i]
+			file = strings.TrimSpace(lines[i+1])
+		)
+		if strings.HasSuffix(fn, ")") {
+			if j := strings.LastIndex(fn, "("); j > 0 {
+				fn = fn[:j]
+			}
+		}
+		if j := strings.LastIndex(file, " +0x"); j >= 0 {
+			file = file[:j]
+		}
+		frames = append(frames, fn+" "+file)
+	}
+	return frames
+}
+
+// panicFrames returns frames of the call stack in a deferred function called by
+// a panic, frames of the deferred function and the runtime are skipped such that
+// the first frame is the panicking one.
+func panicFrames(calldepth int) []string {
+	frames := stackFrames(calldepth + 1)
+	for i := range frames {
+		if !strings.HasPrefix(frames[i], "panic ") {
+			continue
+		}
+		// skips runtime frames such as runtime.sigpanic for runtime errors
+		for i++; i < len(frames) && strings.HasPrefix(frames[i], "runtime."); i++ {
+		}
+		return frames[i:]
+	}
+	return frames
+}
+
+// frameCaller returns the caller of the first frame returned by stackFrames
+func frameCaller(frames []string) Caller {
+	var caller Caller
+	if len(frames) == 0 {
+		return caller
+	}
+	file := frames[0][strings.LastIndex(frames[0], " ")+1:]
+	if i := strings.LastIndex(file, ":"); i > 0 {
+		caller.Line, _ = strconv.Atoi(file[i+1:])
+		file = file[:i]
+	}
+	caller.Filename = file
+	return caller
+}
+
 // provider implements Provider
 type provider struct {
 	writer Writer
 
-	entryListLocker sync.Mutex
-	entryList       *entry
+	// max capacity of entry buffers which could be put back to entryPool
+	poolMaxSize int
 
 	async bool
 
-	// used for async==false
+	// called for every emitted entry
+	levelCounter func(Level)
+
+	// dump entries of ring to dumpTo on fatal
+	fatalDump   *RingWriter
+	fatalDumpTo io.Writer
+
+	// max number of bytes of an entry, unlimited if maxEntrySize <= 0
+	maxEntrySize int
+	truncated    int64
+
+	// provides current time for header
+	clock Clock
+
+	// trimmed prefixes of file names, see WithTrimPath
+	trimPaths []string
+
+	// writes full names of levels in header, see WithLevelFormat
+	longLevel bool
+	// omits header, see WithNoHeader
+	noHeader bool
+	// replaces '\n' which ends entries, empty if it's '\n', see WithLineEnding
+	lineEnding string
+
+	// suppresses repeated entries, see WithRepeatSuppression
+	suppress bool
+	repeat   repeatState // guarded by writeLocker
+
+	// 1 if any writer implements StructuredWriter, records of entries are
+	// filled only if it's set
+	structured int32
+
+	// called instead of os.Exit on fatal
+	exit func(code int)
+
+	// used for async==false, and guards closing writer
 	writeLocker sync.Mutex
+	closed      int32 // entries are discarded after writer closed
 
 	// used for async==true
-	running int32
-	queue   *queue
-	queueMu sync.Mutex
-	cond    *sync.Cond
-	flush   chan chan struct{}
-	quit    chan struct{}
-	wait    chan struct{}
+	running  int32
+	batch    []Entry // used only by the running goroutine
+	queue    *queue
+	queueMu  sync.Mutex
+	cond     *sync.Cond
+	capacity int // capacity of queue, unbounded if capacity <= 0
+	policy   OverflowPolicy
+	notFull  *sync.Cond // used for policy Block
+	dropped  int64
+	flush    chan chan struct{}
+	quit     chan struct{}
+	wait     chan struct{}
 }
 
 // newProvider creates built in provider
-func newProvider(writer Writer, async bool) Provider {
+func newProvider(writer Writer, async bool, opt *options) Provider {
 	p := &provider{
-		writer:    writer,
-		entryList: new(entry),
-		async:     async,
+		writer:      writer,
+		poolMaxSize: opt.encoding.bufferPoolMaxSize,
+		async:       async,
+		fatalDump:   opt.fatalDump,
+		fatalDumpTo: opt.fatalDumpTo,
+		exit:        opt.exit,
+		clock:       opt.clock,
+		trimPaths:   newTrimPaths(opt.trimPaths),
+		longLevel:   opt.longLevel,
+		noHeader:    opt.noHeader,
+		lineEnding:  opt.lineEnding,
+		suppress:    opt.repeatSuppression,
+
+		maxEntrySize: opt.maxEntrySize,
+
+		levelCounter: opt.levelCounter,
+	}
+	if p.poolMaxSize <= 0 {
+		p.poolMaxSize = defaultBufferPoolMaxSize
+	}
+	if p.exit == nil {
+		p.exit = os.Exit
+	}
+	if p.clock == nil {
+		p.clock = stdClock{}
+	}
+	if p.lineEnding == "\n" {
+		p.lineEnding = ""
 	}
+	p.setStructured()
 	if async {
 		p.queue = newQueue()
 		p.cond = sync.NewCond(&p.queueMu)
+		p.capacity = opt.queueCapacity
+		p.policy = opt.queuePolicy
+		p.notFull = sync.NewCond(&p.queueMu)
 		p.flush = make(chan chan struct{}, 1)
 		p.quit = make(chan struct{})
 		p.wait = make(chan struct{})
@@ -83,25 +232,23 @@ func newProvider(writer Writer, async bool) Provider {
 
 // Start implements Provider Start method
 func (p *provider) Start() error {
-	if p.queue == nil {
-		return errors.New("queue is nil")
-	}
 	if !atomic.CompareAndSwapInt32(&p.running, 0, 1) {
 		return errors.New("provider already running")
 	}
-	go p.run()
+	if p.queue != nil {
+		go p.run()
+	}
 	return nil
 }
 
 func (p *provider) run() {
 	for {
 		p.cond.L.Lock()
-		if p.queue.size() == 0 {
+		if p.queue.size() == 0 && atomic.LoadInt32(&p.running) != 0 {
 			p.cond.Wait()
 		}
-		entries := p.queue.popAll()
 		p.cond.L.Unlock()
-		p.writeEntries(entries)
+		p.flushAll()
 		if p.consumeSignals() {
 			break
 		}
@@ -125,82 +272,285 @@ func (p *provider) consumeSignals() bool {
 	}
 }
 
+// flushAll writes all queued entries, writeLocker is held while popping and
+// writing entries, so that setWriter couldn't swap the writer in between.
 func (p *provider) flushAll() {
+	p.writeLocker.Lock()
+	defer p.writeLocker.Unlock()
 	p.cond.L.Lock()
 	entries := p.queue.popAll()
+	p.notFull.Broadcast()
 	p.cond.L.Unlock()
 	p.writeEntries(entries)
 }
 
+// setWriter replaces the writer by w and returns the old one, queued entries
+// are written to the old writer before replacing.
+func (p *provider) setWriter(w Writer) (Writer, error) {
+	p.writeLocker.Lock()
+	defer p.writeLocker.Unlock()
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return nil, errProviderClosed
+	}
+	if p.queue != nil {
+		p.cond.L.Lock()
+		entries := p.queue.popAll()
+		p.notFull.Broadcast()
+		p.cond.L.Unlock()
+		p.writeEntries(entries)
+	}
+	p.writeRepeated()
+	p.repeat.body = nil
+	old := p.writer
+	p.writer = w
+	p.setStructured()
+	return old, nil
+}
+
+// setStructured updates whether records should be filled for the writer
+func (p *provider) setStructured() {
+	var v int32
+	if hasStructuredWriter(p.writer) {
+		v = 1
+	}
+	atomic.StoreInt32(&p.structured, v)
+}
+
+// fillRecord fills the record of entry from the finished entry, so that the
+// message includes the stack trace of fatal entries, truncation and line
+// endings as written, except the final line ending. prefix and fields are
+// the lengths of parts which lead the body, the time is the one of the entry
+// if it's known.
+func (p *provider) fillRecord(e *entry, caller Caller, prefix string, fields int, frames []string) {
+	body := e.buf.Bytes()[e.header:]
+	if len(prefix) > 0 && len(body) >= len(prefix)+3 {
+		// drops "(prefix) "
+		body = body[len(prefix)+3:]
+	}
+	lineEnding := p.lineEnding
+	if lineEnding == "" {
+		lineEnding = "\n"
+	}
+	body = bytes.TrimSuffix(body, []byte(lineEnding))
+	if e.level == LevelFatal {
+		// the stack trace is recorded as frames rather than in the message
+		marker := lineEnding + strings.TrimSuffix(beginStackTrace, "\n")
+		if i := bytes.LastIndex(body, []byte(marker)); i >= 0 {
+			body = body[:i]
+		}
+	}
+	if fields < 2 || fields > len(body) {
+		fields = 0
+	}
+	now := e.time
+	if now.IsZero() {
+		now = p.clock.Now()
+	}
+	e.record = Record{
+		Level:   e.level,
+		Time:    now,
+		Caller:  caller,
+		Prefix:  prefix,
+		Message: string(body[fields:]),
+		Stack:   frames,
+	}
+	if fields > 0 {
+		// drops the trailing space after '}', fields are decoded once here
+		// rather than by every structured writer
+		e.record.RawFields = string(body[:fields-1])
+		e.record.Fields, _ = ParseFields(e.record.RawFields)
+	}
+}
+
 func (p *provider) writeEntries(entries []*entry) {
+	if _, ok := p.writer.(BatchWriter); !ok || len(entries) <= 1 || p.suppress || atomic.LoadInt32(&p.structured) != 0 {
+		for _, e := range entries {
+			p.writeEntry(e)
+		}
+		return
+	}
 	for _, e := range entries {
-		p.writeEntry(e)
+		p.batch = append(p.batch, Entry{
+			Level:     e.level,
+			Data:      e.buf.Bytes(),
+			HeaderLen: e.header,
+		})
 	}
+	writeBatch(p.writer, p.batch)
+	for i, e := range entries {
+		p.batch[i] = Entry{}
+		p.putEntry(e)
+	}
+	p.batch = p.batch[:0]
 }
 
 // Shutdown implements Provider Shutdown method
 func (p *provider) Shutdown() error {
-	if p.queue == nil {
-		return nil
-	}
 	if !atomic.CompareAndSwapInt32(&p.running, 1, 0) {
 		return nil
 	}
-	close(p.quit)
-	p.cond.Signal()
-	<-p.wait
-	p.writer.Close()
-	return nil
+	if p.queue != nil {
+		close(p.quit)
+		// signal with lock held, otherwise the signal may be lost if the
+		// running goroutine is about to wait
+		p.cond.L.Lock()
+		p.cond.Signal()
+		p.cond.L.Unlock()
+		<-p.wait
+		p.cond.L.Lock()
+		p.notFull.Broadcast()
+		p.cond.L.Unlock()
+	}
+	p.writeLocker.Lock()
+	defer p.writeLocker.Unlock()
+	p.writeRepeated()
+	atomic.StoreInt32(&p.closed, 1)
+	return p.writer.Close()
 }
 
 // Print implements Provider Print method
 func (p *provider) Print(level Level, flags int, caller Caller, prefix, msg string) {
-	p.output(level, flags, caller, prefix, msg)
+	p.output(level, flags, caller, prefix, msg, 0, time.Time{})
+	p.exitIfFatal(level)
+}
+
+// print is same as Print but msg starts with encoded fields of length fields
+// which are split from the message for structured writers, and the time of
+// the entry is now if it's not zero
+func (p *provider) print(level Level, flags int, caller Caller, prefix, msg string, fields int, now time.Time) {
+	p.output(level, flags, caller, prefix, msg, fields, now)
+	p.exitIfFatal(level)
+}
+
+func (p *provider) exitIfFatal(level Level) {
 	if level == LevelFatal {
 		p.Shutdown()
-		os.Exit(1)
+		if p.fatalDump != nil {
+			p.fatalDump.Dump(p.fatalDumpTo)
+		}
+		p.exit(1)
 	}
 }
 
 func (p *provider) writeEntry(e *entry) {
-	p.writer.Write(e.level, e.buf.Bytes(), e.header)
+	if p.suppress && p.suppressed(e) {
+		p.putEntry(e)
+		return
+	}
+	if atomic.LoadInt32(&p.structured) != 0 {
+		writeRecord(p.writer, e)
+	} else {
+		write(p.writer, e.level, e.buf.Bytes(), e.header)
+	}
 	p.putEntry(e)
 }
 
-func (p *provider) getEntry() *entry {
-	p.entryListLocker.Lock()
-	if b := p.entryList; b != nil {
-		p.entryList = b.next
-		b.next = nil
-		b.reset()
-		p.entryListLocker.Unlock()
-		return b
+// maxRepeatWait is the max duration a summary of repeated entries waits for
+// the next different entry
+const maxRepeatWait = 30 * time.Second
+
+// repeatState holds the last entry written while suppressing repeated entries
+type repeatState struct {
+	level Level
+	flags int
+	body  []byte // the entry without header
+	n     int    // number of suppressed entries
+	timer *time.Timer
+}
+
+// suppressed reports whether e repeats the last entry, the summary of
+// suppressed entries is written before e otherwise. writeLocker must be held.
+func (p *provider) suppressed(e *entry) bool {
+	body := e.buf.Bytes()[e.header:]
+	if p.repeat.body != nil && e.level == p.repeat.level && bytes.Equal(body, p.repeat.body) {
+		p.repeat.n++
+		if p.repeat.timer == nil {
+			p.repeat.timer = time.AfterFunc(maxRepeatWait, p.flushRepeated)
+		}
+		return true
 	}
-	p.entryListLocker.Unlock()
-	return new(entry)
+	p.writeRepeated()
+	p.repeat.level = e.level
+	p.repeat.flags = e.flags
+	p.repeat.body = append(p.repeat.body[:0], body...)
+	return false
 }
 
-func (p *provider) putEntry(e *entry) {
-	if e.buf.Len() > 256 {
+// flushRepeated writes the summary of repeated entries if the next different
+// entry hasn't come in time
+func (p *provider) flushRepeated() {
+	p.writeLocker.Lock()
+	defer p.writeLocker.Unlock()
+	if atomic.LoadInt32(&p.closed) == 0 {
+		p.writeRepeated()
+	}
+}
+
+// writeRepeated writes the summary of suppressed entries like
+// "... (repeated 3 times)". writeLocker must be held.
+func (p *provider) writeRepeated() {
+	if p.repeat.timer != nil {
+		p.repeat.timer.Stop()
+		p.repeat.timer = nil
+	}
+	if p.repeat.n == 0 {
 		return
 	}
-	p.entryListLocker.Lock()
-	e.next = p.entryList
-	p.entryList = e
-	p.entryListLocker.Unlock()
+	level := p.repeat.level
+	e := p.formatHeader(level, Caller{}, p.repeat.flags&^(Lshortfile|Llongfile), time.Time{})
+	e.header = e.buf.Len()
+	e.level = level
+	e.buf.WriteString("... (repeated ")
+	e.buf.WriteString(strconv.Itoa(p.repeat.n))
+	e.buf.WriteString(" times)")
+	if p.lineEnding != "" {
+		e.buf.WriteString(p.lineEnding)
+	} else {
+		e.buf.WriteByte('\n')
+	}
+	p.repeat.n = 0
+	if atomic.LoadInt32(&p.structured) != 0 {
+		p.fillRecord(e, Caller{}, "", 0, nil)
+		writeRecord(p.writer, e)
+	} else {
+		write(p.writer, e.level, e.buf.Bytes(), e.header)
+	}
+	p.putEntry(e)
+}
+
+func (p *provider) getEntry() *entry {
+	e := entryPool.Get().(*entry)
+	e.reset()
+	return e
 }
 
-// [L yyyy/MM/dd hh:mm:ss.uuu file:line]
-func (p *provider) formatHeader(level Level, caller Caller, flags int) *entry {
+func (p *provider) putEntry(e *entry) {
+	if e.buf.Cap() <= p.poolMaxSize {
+		entryPool.Put(e)
+	}
+}
+
+// [L yyyy/MM/dd hh:mm:ss.uuu #goid file:line] or [LEVEL ...] if longLevel
+func (p *provider) formatHeader(level Level, caller Caller, flags int, now time.Time) *entry {
 	var (
-		e   = p.getEntry()
-		off int
+		e     = p.getEntry()
+		off   int
+		begin int // begin of e.tmp to be written
 	)
 	e.tmp[0] = '['
 	e.tmp[1] = getLevelByte(level)
 	off = 2
+	if p.longLevel {
+		// writes the full name of level instead of e.tmp[:2]
+		e.buf.WriteByte('[')
+		e.buf.WriteString(level.String())
+		begin = 2
+	}
 	if flags&Ltimestamp != 0 {
-		now := time.Now()
+		if now.IsZero() {
+			now = p.clock.Now()
+		}
+		e.time = now
 		if flags&LUTC != 0 {
 			now = now.In(time.UTC)
 		}
@@ -226,9 +576,15 @@ func (p *provider) formatHeader(level Level, caller Caller, flags int) *entry {
 			off += 6
 		}
 	}
+	if flags&Lgoid != 0 {
+		e.tmp[off] = ' '
+		e.tmp[off+1] = '#'
+		off += 2
+		off += someDigits(e, off, int(GoID()))
+	}
 	if caller.Line > 0 {
 		e.tmp[off] = ' '
-		e.buf.Write(e.tmp[:off+1])
+		e.buf.Write(e.tmp[begin : off+1])
 		e.buf.WriteString(caller.Filename)
 		e.tmp[0] = ':'
 		n := someDigits(e, 1, caller.Line)
@@ -238,13 +594,16 @@ func (p *provider) formatHeader(level Level, caller Caller, flags int) *entry {
 	} else {
 		e.tmp[off] = ']'
 		e.tmp[off+1] = ' '
-		e.buf.Write(e.tmp[:off+2])
+		e.buf.Write(e.tmp[begin : off+2])
 	}
 
 	return e
 }
 
-func (p *provider) output(level Level, flags int, caller Caller, prefix, msg string) {
+func (p *provider) output(level Level, flags int, caller Caller, prefix, msg string, fields int, now time.Time) {
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return
+	}
 	if flags&(Lshortfile|Llongfile) != 0 {
 		if caller.Line <= 0 {
 			caller.Filename = "???"
@@ -254,9 +613,19 @@ func (p *provider) output(level Level, flags int, caller Caller, prefix, msg str
 			if slash >= 0 {
 				caller.Filename = caller.Filename[slash+1:]
 			}
+		} else if len(p.trimPaths) > 0 {
+			caller.Filename = trimPath(p.trimPaths, caller.Filename)
 		}
 	}
-	e := p.formatHeader(level, caller, flags)
+	var e *entry
+	if p.noHeader {
+		e = p.getEntry()
+	} else {
+		e = p.formatHeader(level, caller, flags, now)
+	}
+	if e.time.IsZero() {
+		e.time = now
+	}
 	e.header = e.buf.Len()
 	if len(prefix) > 0 {
 		e.buf.WriteByte('(')
@@ -270,24 +639,201 @@ func (p *provider) output(level Level, flags int, caller Caller, prefix, msg str
 	if e.buf.Bytes()[e.buf.Len()-1] != '\n' {
 		e.buf.WriteByte('\n')
 	}
+	var (
+		lineBegin = e.buf.Len() - 1
+		frames    []string
+	)
 	if level == LevelFatal {
-		stackBuf := stack(4)
-		e.buf.WriteString("========= BEGIN STACK TRACE =========\n")
-		e.buf.Write(stackBuf)
-		e.buf.WriteString("========== END STACK TRACE ==========\n")
+		stackBegin := e.buf.Len()
+		trace := stack(4)
+		if atomic.LoadInt32(&p.structured) != 0 {
+			frames = parseFrames(trace)
+		}
+		e.buf.WriteString(beginStackTrace)
+		e.buf.Write(trace)
+		e.buf.WriteString(endStackTrace)
+		if p.maxEntrySize > 0 && e.buf.Len() > p.maxEntrySize {
+			lineBegin = p.truncateFatal(e, stackBegin)
+		}
+	} else if p.maxEntrySize > 0 && e.buf.Len()+p.lineExtra() > p.maxEntrySize {
+		// the line ending replaces '\n' later, so room is reserved for it
+		truncateEntry(e, e.header, p.maxEntrySize-p.lineExtra(), 0)
+		atomic.AddInt64(&p.truncated, 1)
+		lineBegin = e.buf.Len() - 1
+	}
+	if p.lineEnding != "" {
+		p.replaceLineEndings(e, lineBegin)
 	}
 	e.level = level
+	e.flags = flags
+	if atomic.LoadInt32(&p.structured) != 0 {
+		p.fillRecord(e, caller, prefix, fields, frames)
+	}
+	queued := false
 	if p.queue != nil && atomic.LoadInt32(&p.running) != 0 {
 		p.cond.L.Lock()
-		if p.queue.push(e) == 1 {
-			p.cond.Signal()
+		if p.capacity > 0 && !p.reserve() {
+			p.cond.L.Unlock()
+			p.putEntry(e)
+			return
+		}
+		// the provider may be shut down while blocked by reserve, then
+		// nothing consumes the queue and the entry is written synchronously
+		if atomic.LoadInt32(&p.running) != 0 {
+			if p.queue.push(e) == 1 {
+				p.cond.Signal()
+			}
+			queued = true
 		}
 		p.cond.L.Unlock()
-	} else {
+	}
+	if !queued {
 		p.writeLocker.Lock()
+		if atomic.LoadInt32(&p.closed) != 0 {
+			p.writeLocker.Unlock()
+			p.putEntry(e)
+			return
+		}
 		p.writeEntry(e)
 		p.writeLocker.Unlock()
 	}
+	// only accepted entries are counted
+	if p.levelCounter != nil {
+		p.levelCounter(level)
+	}
+}
+
+// reserve makes room for a new entry in the bounded queue by policy, it
+// returns false if the new entry should be dropped. It returns true without
+// room if the provider is shut down while blocked, so the caller must check
+// running again. The lock of queue must be held.
+func (p *provider) reserve() bool {
+	for p.queue.size() >= p.capacity {
+		switch p.policy {
+		case DropNewest:
+			atomic.AddInt64(&p.dropped, 1)
+			return false
+		case DropOldest:
+			p.putEntry(p.queue.popFront())
+			atomic.AddInt64(&p.dropped, 1)
+		default:
+			if atomic.LoadInt32(&p.running) == 0 {
+				return true
+			}
+			p.notFull.Wait()
+		}
+	}
+	return true
+}
+
+const (
+	beginStackTrace = "========= BEGIN STACK TRACE =========\n"
+	endStackTrace   = "========== END STACK TRACE ==========\n"
+)
+
+// lineExtra returns the number of bytes added by replacing '\n' by the line ending
+func (p *provider) lineExtra() int {
+	if len(p.lineEnding) > 1 {
+		return len(p.lineEnding) - 1
+	}
+	return 0
+}
+
+// replaceLineEndings replaces each '\n' of the entry since offset from by
+// the line ending, the line which has already been ended by the line
+// ending is kept, e.g. the message ends with "\r\n".
+func (p *provider) replaceLineEndings(e *entry, from int) {
+	data := e.buf.Bytes()
+	if from < 0 || from >= len(data) {
+		return
+	}
+	var tail []byte
+	if from+1 < len(data) {
+		// lines of the stack trace of fatal entries
+		tail = append(tail, data[from+1:]...)
+	}
+	switch {
+	case hasSuffix(data[:from+1], p.lineEnding):
+		e.buf.Truncate(from + 1)
+	case hasSuffix(data[:from], p.lineEnding):
+		// '\n' was appended to the message which ends with the line ending
+		e.buf.Truncate(from)
+	default:
+		e.buf.Truncate(from)
+		e.buf.WriteString(p.lineEnding)
+	}
+	for _, c := range tail {
+		if c == '\n' {
+			e.buf.WriteString(p.lineEnding)
+		} else {
+			e.buf.WriteByte(c)
+		}
+	}
+}
+
+func hasSuffix(data []byte, suffix string) bool {
+	return len(data) >= len(suffix) && string(data[len(data)-len(suffix):]) == suffix
+}
+
+// truncateEntry truncates bytes after offset from of the entry and appends
+// a marker, so that the entry is not larger than max bytes if possible. The
+// argument dropped is number of bytes already dropped from the entry, which
+// is counted into the marker.
+func truncateEntry(e *entry, from, max, dropped int) {
+	var (
+		size = e.buf.Len()
+		// reserve the marker for the max number of truncated bytes
+		cut = max - len("…(truncated  bytes)\n") - len(strconv.Itoa(size+dropped))
+	)
+	if cut > size {
+		cut = size
+	} else if cut < from {
+		cut = from
+	}
+	data := e.buf.Bytes()
+	for cut > from && cut < size && !utf8.RuneStart(data[cut]) {
+		cut--
+	}
+	e.buf.Truncate(cut)
+	e.buf.WriteString("…(truncated ")
+	e.buf.WriteString(strconv.Itoa(size - cut + dropped))
+	e.buf.WriteString(" bytes)\n")
+}
+
+// truncateFatal truncates the fatal entry whose stack trace starts at
+// offset stackBegin, the stack trace is truncated first and both of the
+// stack trace markers are kept. It returns the offset of '\n' which ends the
+// body.
+func (p *provider) truncateFatal(e *entry, stackBegin int) int {
+	atomic.AddInt64(&p.truncated, 1)
+	var (
+		size    = e.buf.Len()
+		markers = len(beginStackTrace) + len(endStackTrace)
+		reserve = len("…(truncated  bytes)\n") + len(strconv.Itoa(size))
+	)
+	if stackBegin+markers+reserve <= p.maxEntrySize {
+		// truncates the stack trace only
+		e.buf.Truncate(size - len(endStackTrace))
+		truncateEntry(e, stackBegin+len(beginStackTrace), p.maxEntrySize-len(endStackTrace), 0)
+	} else {
+		// drops the stack trace and truncates the body
+		e.buf.Truncate(stackBegin)
+		truncateEntry(e, e.header, p.maxEntrySize-markers, size-stackBegin-markers)
+		stackBegin = e.buf.Len()
+		e.buf.WriteString(beginStackTrace)
+	}
+	e.buf.WriteString(endStackTrace)
+	return stackBegin - 1
+}
+
+// Truncated returns the number of truncated entries
+func (p *provider) Truncated() int64 {
+	return atomic.LoadInt64(&p.truncated)
+}
+
+// Dropped returns the number of dropped entries
+func (p *provider) Dropped() int64 {
+	return atomic.LoadInt64(&p.dropped)
 }
 
 type emptyProvider struct{}
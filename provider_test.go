@@ -0,0 +1,349 @@
+package log_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gopherd/log"
+)
+
+func TestStartShutdownImmediately(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			logger := log.NewLogger("")
+			logger.Start(log.WithWriters(&testingLogWriter{discard: true}))
+			logger.Shutdown()
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("shutdown hangs")
+	}
+}
+
+type shutdownWriter struct {
+	testingLogWriter
+	closed bool
+}
+
+func (w *shutdownWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestSyncStartShutdown(t *testing.T) {
+	writer := new(shutdownWriter)
+	logger := log.NewLogger("")
+	if err := logger.Start(log.WithWriters(writer), log.WithSync(true)); err != nil {
+		t.Fatalf("start error: %v", err)
+	}
+	logger.Info().Print("hello")
+	if err := logger.Shutdown(); err != nil {
+		t.Fatalf("shutdown error: %v", err)
+	}
+	if !writer.closed {
+		t.Error("writer not closed by shutdown")
+	}
+	if got, want := writer.buf.String(), "[INFO] hello\n"; got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+}
+
+// countingWriter counts calls of Write, the first call blocks until gate closed
+type countingWriter struct {
+	gate    chan struct{}
+	entered chan struct{} // closed when the first call entered if not nil
+	calls   int
+	buf     bytes.Buffer
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.calls == 0 {
+		if w.entered != nil {
+			close(w.entered)
+		}
+		<-w.gate
+	}
+	w.calls++
+	return w.buf.Write(p)
+}
+
+func TestBatchWrite(t *testing.T) {
+	const n = 100
+	writer := &countingWriter{gate: make(chan struct{})}
+	logger := log.NewLogger("")
+	logger.Start(log.WithOutput(writer), log.WithFlags(0))
+	var want bytes.Buffer
+	for i := 0; i < n; i++ {
+		logger.Info().Print(fmt.Sprint(i))
+		fmt.Fprintf(&want, "[I] %d\n", i)
+	}
+	close(writer.gate)
+	logger.Shutdown()
+	if got := writer.buf.String(); got != want.String() {
+		t.Errorf("want %q, but got %q", want.String(), got)
+	}
+	if writer.calls > 2 {
+		t.Errorf("want at most 2 writes for %d entries, but got %d", n, writer.calls)
+	}
+}
+
+func TestLevelCounter(t *testing.T) {
+	var counts [log.LevelTrace + 1]int
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithWriters(new(testingLogWriter)),
+		log.WithLevel(log.LevelDebug),
+		log.WithLevelCounter(func(level log.Level) { counts[level]++ }),
+	)
+	logger.Info().Print("info")
+	logger.Warn().Print("warn")
+	logger.Info().Print("info")
+	logger.Trace().Print("trace")
+	logger.Shutdown()
+	if counts[log.LevelInfo] != 2 || counts[log.LevelWarn] != 1 || counts[log.LevelTrace] != 0 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+}
+
+func TestBoundedQueue(t *testing.T) {
+	for _, tc := range []struct {
+		policy log.OverflowPolicy
+		want   string
+	}{
+		{log.DropNewest, "[I] 0\n[I] 1\n[I] 2\n"},
+		{log.DropOldest, "[I] 0\n[I] 4\n[I] 5\n"},
+	} {
+		writer := &countingWriter{gate: make(chan struct{}), entered: make(chan struct{})}
+		var counted int64
+		logger := log.NewLogger("")
+		logger.Start(log.WithOutput(writer), log.WithFlags(0), log.WithQueue(2, tc.policy),
+			log.WithLevelCounter(func(log.Level) { atomic.AddInt64(&counted, 1) }))
+		logger.Info().Print("0")
+		<-writer.entered
+		for i := 1; i <= 5; i++ {
+			logger.Info().Print(fmt.Sprint(i))
+		}
+		close(writer.gate)
+		logger.Shutdown()
+		if got := writer.buf.String(); got != tc.want {
+			t.Errorf("policy %d: want %q, but got %q", tc.policy, tc.want, got)
+		}
+		if got := logger.Dropped(); got != 3 {
+			t.Errorf("policy %d: want 3 dropped, but got %d", tc.policy, got)
+		}
+		// entries dropped by DropOldest have been counted when queued
+		if want := map[log.OverflowPolicy]int64{log.DropNewest: 3, log.DropOldest: 6}[tc.policy]; counted != want {
+			t.Errorf("policy %d: want %d counted, but got %d", tc.policy, want, counted)
+		}
+	}
+}
+
+func TestFatalStack(t *testing.T) {
+	code := -1
+	logger, writer := startTestLogger("", log.WithExitFunc(func(c int) { code = c }))
+	logger.Info().Stack("stack").Print("info")
+	logger.Fatal().Print("fatal")
+	if code != 1 {
+		t.Fatalf("want exit code 1, but got %d", code)
+	}
+	got := writer.buf.String()
+	if !strings.HasPrefix(got, `[INFO] {stack:["github.com/gopherd/log_test.TestFatalStack `) {
+		t.Errorf("unexpected stack field %q", got)
+	}
+	if !strings.Contains(got, "[FATAL] fatal\n========= BEGIN STACK TRACE =========\n") ||
+		!strings.HasSuffix(got, "========== END STACK TRACE ==========\n") {
+		t.Errorf("unexpected fatal output %q", got)
+	}
+}
+
+func TestRepeatSuppression(t *testing.T) {
+	logger, writer := startTestLogger("", log.WithRepeatSuppression(true))
+	for i := 0; i < 3; i++ {
+		logger.Info().Print("same")
+	}
+	logger.Warn().Print("same")
+	logger.Info().Int("i", 1).Print("fields")
+	logger.Info().Int("i", 2).Print("fields")
+	logger.Info().Print("tail")
+	logger.Info().Print("tail")
+	logger.Shutdown()
+	want := `[INFO] same
+[INFO] ... (repeated 2 times)
+[WARN] same
+[INFO] {i:1} fields
+[INFO] {i:2} fields
+[INFO] tail
+[INFO] ... (repeated 1 times)
+`
+	if got := writer.buf.String(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+}
+
+func TestGoID(t *testing.T) {
+	id := log.GoID()
+	if id <= 0 {
+		t.Fatalf("want a positive goroutine id, but got %d", id)
+	}
+	other := make(chan int64)
+	go func() { other <- log.GoID() }()
+	if got := <-other; got <= 0 || got == id {
+		t.Errorf("want another positive goroutine id, but got %d", got)
+	}
+
+	var buf bytes.Buffer
+	logger := log.NewLogger("")
+	logger.Start(log.WithOutput(&buf), log.WithSync(true), log.WithFlags(log.Lgoid))
+	logger.Info().Print("goid")
+	logger.Shutdown()
+	if want := fmt.Sprintf("[I #%d] goid\n", id); buf.String() != want {
+		t.Errorf("want %q, but got %q", want, buf.String())
+	}
+}
+
+func TestMaxEntrySize(t *testing.T) {
+	logger, writer := startTestLogger("",
+		log.WithFlags(0),
+		log.WithMaxEntrySize(40),
+	)
+	logger.Info().Print("short")
+	logger.Info().Print("0123456789世界" + strings.Repeat("x", 20))
+	logger.Info().Print(strings.Repeat("x", 100))
+	if got := logger.Truncated(); got != 2 {
+		t.Errorf("want 2 truncated, but got %d", got)
+	}
+	want := "[INFO] short\n" +
+		"[INFO] 0123456789…(truncated 27 bytes)\n" +
+		"[INFO] xxxxxxxxxxx…(truncated 90 bytes)\n"
+	if got := writer.buf.String(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+
+	// the stack trace of fatal is truncated first
+	writer.buf.Reset()
+	logger.Start(log.WithWriters(writer), log.WithSync(true), log.WithFlags(0), log.WithMaxEntrySize(256), log.WithExitFunc(func(int) {}))
+	logger.Fatal().Print("fatal")
+	got := writer.buf.String()
+	if len(got) > len("[FATAL] ")+256-len("[F] ") ||
+		!strings.HasPrefix(got, "[FATAL] fatal\n========= BEGIN STACK TRACE =========\n") ||
+		!strings.Contains(got, " bytes)\n========== END STACK TRACE ==========\n") {
+		t.Errorf("unexpected fatal entry %q", got)
+	}
+
+	// the body is truncated if the stack trace markers couldn't fit
+	writer.buf.Reset()
+	logger.Start(log.WithWriters(writer), log.WithSync(true), log.WithFlags(0), log.WithMaxEntrySize(64), log.WithExitFunc(func(int) {}))
+	logger.Fatal().Print("fatal")
+	got = writer.buf.String()
+	if !strings.HasPrefix(got, "[FATAL] …(truncated ") ||
+		!strings.HasSuffix(got, " bytes)\n========= BEGIN STACK TRACE =========\n========== END STACK TRACE ==========\n") {
+		t.Errorf("unexpected fatal entry %q", got)
+	}
+}
+
+func TestLineEnding(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithOutput(&buf),
+		log.WithSync(true),
+		log.WithFlags(0),
+		log.WithLineEnding("\r\n"),
+		log.WithMaxEntrySize(24),
+		log.WithExitFunc(func(int) {}),
+	)
+	logger.Info().Print("a")
+	logger.Info().Print("b\n")
+	logger.Info().Print("c\r\n")
+	logger.Info().Print(strings.Repeat("x", 30))
+	want := "[I] a\r\n[I] b\r\n[I] c\r\n[I] …(truncated 31 bytes)\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+	logger.Shutdown()
+
+	buf.Reset()
+	logger.Start(log.WithOutput(&buf), log.WithSync(true), log.WithFlags(0), log.WithLineEnding("\r\n"), log.WithExitFunc(func(int) {}))
+	logger.Fatal().Print("fatal")
+	logger.Shutdown()
+	got := buf.String()
+	if !strings.HasPrefix(got, "[F] fatal\r\n========= BEGIN STACK TRACE =========\r\n") ||
+		!strings.HasSuffix(got, "\r\n========== END STACK TRACE ==========\r\n") ||
+		strings.Count(got, "\n") != strings.Count(got, "\r\n") {
+		t.Errorf("unexpected fatal entry %q", got)
+	}
+}
+
+// countingClock counts calls of Now
+type countingClock struct {
+	testClock
+	calls int
+}
+
+func (c *countingClock) Now() time.Time {
+	c.calls++
+	return c.testClock.Now()
+}
+
+func TestStructuredRecordBody(t *testing.T) {
+	var (
+		structured = new(recordWriter)
+		clock      = &countingClock{testClock: testClock{now: time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)}}
+		code       = -1
+		logger     = log.NewLogger("")
+	)
+	logger.Start(
+		log.WithWriters(structured),
+		log.WithSync(true),
+		log.WithClock(clock),
+		log.WithFlags(log.Ltimestamp),
+		log.WithLineEnding("\r\n"),
+		log.WithMaxEntrySize(160),
+		log.WithExitFunc(func(c int) { code = c }),
+	)
+	logger.Info().Int("id", 1).Print("a\nb")
+	logger.Info().Print(strings.Repeat("x", 300))
+	logger.Fatal().Print("fatal")
+	if code != 1 {
+		t.Fatalf("want exit code 1, but got %d", code)
+	}
+	if len(structured.records) != 3 {
+		t.Fatalf("want 3 records, but got %d", len(structured.records))
+	}
+	if clock.calls != 3 {
+		t.Errorf("want the clock read once for every entry, but got %d calls", clock.calls)
+	}
+	if r := structured.records[0]; r.RawFields != "{id:1}" || len(r.Fields) != 1 || r.Message != "a\nb" || !r.Time.Equal(clock.now) {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if r := structured.records[1]; !strings.HasPrefix(r.Message, "xxx") || !strings.Contains(r.Message, "…(truncated ") {
+		t.Errorf("want a truncated message, but got %q", r.Message)
+	}
+	if r := structured.records[2]; r.Message != "fatal" ||
+		!strings.Contains(strings.Join(r.Stack, "\n"), "\ngithub.com/gopherd/log_test.TestStructuredRecordBody ") {
+		t.Errorf("want the stack as frames out of fatal message, but got %q and %q", r.Message, r.Stack)
+	}
+}
+
+func TestPrintAfterShutdown(t *testing.T) {
+	for _, sync := range []bool{true, false} {
+		writer := new(closedWriter)
+		logger := log.NewLogger("")
+		logger.Start(log.WithWriters(writer), log.WithSync(sync))
+		logger.Info().Print("before")
+		logger.Shutdown()
+		logger.Info().Print("after")
+		logger.Clone("clone").Info().Print("after")
+		if want := "[INFO] before\n"; writer.buf.String() != want {
+			t.Errorf("sync %v: want %q, but got %q", sync, want, writer.buf.String())
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package log
+
+import (
+	"io"
+	"sync"
+)
+
+// RingWriter is a writer which keeps the most recent entries in memory.
+// It's useful for dumping recent logs for postmortems, e.g.
+//
+//	ring := log.NewRingWriter(1024)
+//	log.Start(log.WithFile(options), log.WithWriters(ring), log.WithFatalDump(ring, os.Stderr))
+type RingWriter struct {
+	mu      sync.Mutex
+	entries [][]byte
+	next    int
+	full    bool
+}
+
+// NewRingWriter creates a ring writer which holds at most capacity entries
+func NewRingWriter(capacity int) *RingWriter {
+	if capacity <= 0 {
+		panic("log: ring writer capacity must be positive")
+	}
+	return &RingWriter{
+		entries: make([][]byte, capacity),
+	}
+}
+
+// Write implements Writer Write method
+func (w *RingWriter) Write(level Level, data []byte, _ int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.entries[w.next] = append(w.entries[w.next][:0], data...)
+	w.next++
+	if w.next == len(w.entries) {
+		w.next = 0
+		w.full = true
+	}
+	return nil
+}
+
+// Close implements Writer Close method, entries are kept after closed
+func (w *RingWriter) Close() error { return nil }
+
+// Dump writes all entries held by the ring writer to out from oldest to newest
+func (w *RingWriter) Dump(out io.Writer) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.full {
+		if err := w.dump(out, w.entries[w.next:]); err != nil {
+			return err
+		}
+	}
+	return w.dump(out, w.entries[:w.next])
+}
+
+func (w *RingWriter) dump(out io.Writer, entries [][]byte) error {
+	for _, data := range entries {
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
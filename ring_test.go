@@ -0,0 +1,23 @@
+package log_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/gopherd/log"
+)
+
+func TestRingWriter(t *testing.T) {
+	ring := log.NewRingWriter(3)
+	for i := 0; i < 5; i++ {
+		ring.Write(log.LevelInfo, []byte(fmt.Sprintf("entry %d\n", i)), 0)
+	}
+	var buf bytes.Buffer
+	ring.Dump(&buf)
+	got := buf.String()
+	want := "entry 2\nentry 3\nentry 4\n"
+	if got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+}
@@ -0,0 +1,96 @@
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// Sampler decides whether entries are logged, see WithSampler
+type Sampler interface {
+	// Sample reports whether the entry identified by key should be logged
+	Sample(level Level, key string) bool
+}
+
+// TimedSampler is an optional interface which could be implemented by Sampler.
+// The logger calls SampleAt instead of Sample with the time of the entry read
+// by its clock, see WithClock.
+type TimedSampler interface {
+	Sampler
+	SampleAt(level Level, key string, now time.Time) bool
+}
+
+// SampleKeyFunc derives the sampling key of an entry from its template, i.e.
+// the format of Printf-like functions or the message of Print, see WithSampleKey
+type SampleKeyFunc func(level Level, template string) string
+
+// maxSampleKeys is the max number of keys counted by the sampler created by
+// NewSampler, counters are reset if there are more keys
+const maxSampleKeys = 4096
+
+type sampleKey struct {
+	level Level
+	key   string
+}
+
+type sampleCounter struct {
+	since int64 // unix nano of the beginning of the current tick
+	n     int
+}
+
+type sampler struct {
+	first      int
+	thereafter int
+	tick       int64
+
+	mu       sync.Mutex
+	counters map[sampleKey]*sampleCounter
+}
+
+// NewSampler creates a sampler which logs the first n entries with the same
+// key and level in each tick, and thereafter every m-th entry. Entries after
+// the first n are all dropped if thereafter <= 0. Ticks are measured by the
+// clock of the logger, see TimedSampler, e.g.
+//
+//	log.Start(log.WithSampler(log.NewSampler(10, 100, time.Second)))
+//	for id := range failures {
+//		log.Warnf("user %d failed", id) // sampled as one site
+//	}
+func NewSampler(first, thereafter int, tick time.Duration) Sampler {
+	if tick <= 0 {
+		panic("log: sampler tick must be positive")
+	}
+	return &sampler{
+		first:      first,
+		thereafter: thereafter,
+		tick:       int64(tick),
+		counters:   make(map[sampleKey]*sampleCounter),
+	}
+}
+
+// Sample implements Sampler Sample method
+func (s *sampler) Sample(level Level, key string) bool {
+	return s.SampleAt(level, key, time.Now())
+}
+
+// SampleAt implements TimedSampler SampleAt method
+func (s *sampler) SampleAt(level Level, key string, t time.Time) bool {
+	now := t.UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := sampleKey{level, key}
+	c, ok := s.counters[k]
+	if !ok {
+		if len(s.counters) >= maxSampleKeys {
+			s.counters = make(map[sampleKey]*sampleCounter)
+		}
+		c = &sampleCounter{since: now}
+		s.counters[k] = c
+	} else if now-c.since >= s.tick {
+		c.since, c.n = now, 0
+	}
+	c.n++
+	if c.n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (c.n-s.first)%s.thereafter == 0
+}
@@ -0,0 +1,73 @@
+package log_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopherd/log"
+)
+
+func TestSampler(t *testing.T) {
+	testOutputs(t, []outputCase{
+		{
+			name:    "first and thereafter",
+			options: []log.Option{log.WithSampler(log.NewSampler(2, 3, time.Hour))},
+			print: func(logger *log.Logger) {
+				for i := 0; i < 10; i++ {
+					logger.Infof("user %d failed", i)
+					logger.Warn().Int("i", i).Printf("retry %d", i)
+				}
+			},
+			want: `[INFO] user 0 failed
+[WARN] {i:0} retry 0
+[INFO] user 1 failed
+[WARN] {i:1} retry 1
+[INFO] user 4 failed
+[WARN] {i:4} retry 4
+[INFO] user 7 failed
+[WARN] {i:7} retry 7
+`,
+		},
+		{
+			name: "keys derived from messages",
+			options: []log.Option{
+				log.WithSampler(log.NewSampler(1, 0, time.Hour)),
+				log.WithSampleKey(func(level log.Level, template string) string {
+					return strings.Fields(template)[0]
+				}),
+			},
+			print: func(logger *log.Logger) {
+				for i := 0; i < 3; i++ {
+					logger.Info().Print(fmt.Sprintf("step %d", i))
+				}
+			},
+			want: "[INFO] step 0\n",
+		},
+	})
+
+	// fatal entries are never dropped
+	logger, writer := startTestLogger("", log.WithExitFunc(func(int) {}), log.WithSampler(log.NewSampler(0, 0, time.Hour)))
+	logger.Info().Print("dropped")
+	logger.Fatal().Print("fatal")
+	logger.Shutdown()
+	if got := writer.buf.String(); !strings.HasPrefix(got, "[FATAL] fatal\n") {
+		t.Errorf("unexpected entries %q", got)
+	}
+}
+
+func TestSamplerClock(t *testing.T) {
+	clock := &testClock{now: time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)}
+	logger, writer := startTestLogger("", log.WithClock(clock), log.WithSampler(log.NewSampler(1, 0, time.Second)))
+	for i := 0; i < 4; i++ {
+		logger.Info().Int("i", i).Print("tick")
+		logger.Infof("tick %d", i)
+		clock.now = clock.now.Add(600 * time.Millisecond)
+	}
+	logger.Shutdown()
+	want := "[INFO] {i:0} tick\n[INFO] tick 0\n[INFO] {i:2} tick\n[INFO] tick 2\n"
+	if got := writer.buf.String(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build !windows
+// +build !windows
+
+package log_test
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gopherd/log"
+)
+
+type reopenWriter struct {
+	testingLogWriter
+	err error
+}
+
+func (w *reopenWriter) Reopen() error { return w.err }
+
+func TestReopenSignalErrorHandler(t *testing.T) {
+	errReopen := errors.New("reopen failed")
+	errs := make(chan error, 1)
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithWriters(&reopenWriter{err: errReopen}),
+		log.WithReopenSignal(syscall.SIGHUP),
+		log.WithErrorHandler(func(err error) { errs <- err }),
+	)
+	defer logger.Shutdown()
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, errReopen) {
+			t.Errorf("want %v, but got %v", errReopen, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("error not handled")
+	}
+}
@@ -313,7 +313,7 @@ func (enc *encoder) encodeStrings(s []string) {
 	enc.writeByte(']')
 }
 
-func (enc *encoder) encodeBytes(s []byte) {
+func (enc *encoder) encodeHexBytes(s []byte) {
 	enc.writeString("0x")
 	for i := range s {
 		h, l := s[i]>>4, s[i]&0xF
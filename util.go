@@ -1,9 +1,15 @@
 package log
 
 import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 	"unicode"
+	"unicode/utf8"
 	"unsafe"
 )
 
@@ -26,6 +32,67 @@ func isIdentRune(ch rune, i int) bool {
 		unicode.IsLetter(ch) || unicode.IsDigit(ch)
 }
 
+// encoderOptions holds options of encoder which specified by Start
+type encoderOptions struct {
+	dedupeKeys        bool
+	bytesEncoding     BytesEncoding
+	bufferPoolMaxSize int
+	redactKeys        []string
+	redactMask        string
+	maxFieldLen       int
+	nonFinite         NonFinitePolicy
+	fieldTransform    func(key string) (string, bool)
+	fieldsPosition    FieldsPosition
+}
+
+// FieldsPosition represents the position of fields relative to the message
+type FieldsPosition int
+
+// FieldsPosition constants
+const (
+	FieldsBefore FieldsPosition = iota // {k:"v"} message (default)
+	FieldsAfter                        // message {k:"v"}
+)
+
+// NonFinitePolicy represents how to encode non-finite floats, i.e. NaN and ±Inf
+type NonFinitePolicy int
+
+// NonFinitePolicy constants
+const (
+	NonFiniteLiteral NonFinitePolicy = iota // literal NaN, +Inf, -Inf (default)
+	NonFiniteString                         // quoted "NaN", "+Inf", "-Inf"
+	NonFiniteNil                            // nil
+)
+
+// defaultRedactMask is the default mask of redacted values
+const defaultRedactMask = "******"
+
+// redacts reports whether the value of key should be masked
+func (opts *encoderOptions) redacts(key string) bool {
+	for i := range opts.redactKeys {
+		if strings.EqualFold(opts.redactKeys[i], key) {
+			return true
+		}
+	}
+	return false
+}
+
+// BytesEncoding represents the encoding of byte slices
+type BytesEncoding int
+
+// BytesEncoding constants
+const (
+	HexBytes       BytesEncoding = iota // 0x prefixed hex, e.g. 0x313378 (default)
+	Base64Bytes                         // quoted standard base64, e.g. "MTN4"
+	Base64URLBytes                      // quoted URL-safe base64, e.g. "MTN4"
+)
+
+// encodedKey records the offset of an encoded field within encoder.buf
+type encodedKey struct {
+	key string
+	off int
+}
+
 // encoder used to build json with some extra features:
 //
 // 1. support unqutoed key
@@ -34,7 +101,29 @@ func isIdentRune(ch rune, i int) bool {
 // 4. support literal nil
 // 5. support bytes starts with 0x
 type encoder struct {
-	buf []byte
+	buf  []byte
+	opts *encoderOptions
+	keys []encodedKey // used only if opts.dedupeKeys
+
+	// offset of the value to be masked, or -1 if there is no such value.
+	// redactDepth is the number of nested objects when the value began, the
+	// value is masked once objects opened by it are closed.
+	redactOff   int
+	redactDepth int
+
+	// nested holds a state for each open nested object, the state reports
+	// whether any field has been written into the object
+	nested []bool
+
+	// length of encoded fields including the trailing "} ", set by finish
+	fields int
+
+	// offset of the field skipped by opts.fieldTransform, or -1 if there is
+	// no such field. The field is encoded and removed lazily like redactOff,
+	// skipDepth and skipNested are the nested states when the field began.
+	skipOff    int
+	skipDepth  int
+	skipNested bool
 }
 
 // String returns the accumulated string.
@@ -64,8 +153,53 @@ func (enc *encoder) grow(n int) {
 	enc.buf = buf
 }
 
-func (enc *encoder) reset() {
+func (enc *encoder) reset(opts *encoderOptions) {
 	enc.buf = enc.buf[:0]
+	enc.opts = opts
+	enc.keys = enc.keys[:0]
+	enc.redactOff = -1
+	enc.nested = enc.nested[:0]
+	enc.fields = 0
+	enc.skipOff = -1
+}
+
+// settle completes the last encoded field, i.e. masks its value if redacted
+// or removes it if skipped
+func (enc *encoder) settle() {
+	enc.redact()
+	enc.drop()
+}
+
+// redact replaces the value encoded since redactOff by the mask unless its
+// value is an object which has not been closed yet
+func (enc *encoder) redact() {
+	if enc.redactOff < 0 || len(enc.nested) > enc.redactDepth {
+		return
+	}
+	enc.buf = enc.buf[:enc.redactOff]
+	if enc.skipOff >= enc.redactOff {
+		// the skipped field is within the masked value
+		enc.skipOff = -1
+	}
+	enc.redactOff = -1
+	if enc.opts.redactMask != "" {
+		enc.encodeString(enc.opts.redactMask)
+	} else {
+		enc.encodeString(defaultRedactMask)
+	}
+}
+
+// drop removes the field skipped since skipOff unless its value is an object
+// which has not been closed yet
+func (enc *encoder) drop() {
+	if enc.skipOff < 0 || len(enc.nested) > enc.skipDepth {
+		return
+	}
+	enc.buf = enc.buf[:enc.skipOff]
+	enc.skipOff = -1
+	if enc.skipDepth > 0 {
+		enc.nested[enc.skipDepth-1] = enc.skipNested
+	}
 }
 
 func (enc *encoder) writeByte(c byte) {
@@ -77,23 +211,225 @@ func (enc *encoder) writeString(s string) {
 }
 
 func (enc *encoder) encodeKey(key string) {
+	enc.settle()
+	if enc.opts != nil && enc.opts.fieldTransform != nil && enc.skipOff < 0 {
+		var skip bool
+		if key, skip = enc.opts.fieldTransform(key); skip {
+			// the field is encoded as usual and removed by the next settle
+			enc.skipOff = len(enc.buf)
+			enc.skipDepth = len(enc.nested)
+			if enc.skipDepth > 0 {
+				enc.skipNested = enc.nested[enc.skipDepth-1]
+			}
+			enc.encodeSkippedKey(key)
+			return
+		}
+	}
+	if n := len(enc.nested); n > 0 {
+		// fields of nested objects are neither deduplicated nor prefixed by '{'
+		if enc.nested[n-1] {
+			enc.writeByte(',')
+		}
+		enc.nested[n-1] = true
+		enc.encodeFieldKey(key)
+		return
+	}
+	if enc.opts != nil && enc.opts.dedupeKeys {
+		enc.removeKey(key)
+		enc.keys = append(enc.keys, encodedKey{key: key, off: len(enc.buf)})
+	}
 	if len(enc.buf) == 0 {
 		enc.writeByte('{')
 	} else {
 		enc.writeByte(',')
 	}
+	enc.encodeFieldKey(key)
+}
+
+// encodeSkippedKey encodes the key of a skipped field, which is neither
+// deduplicated nor redacted
+func (enc *encoder) encodeSkippedKey(key string) {
+	if n := len(enc.nested); n > 0 {
+		if enc.nested[n-1] {
+			enc.writeByte(',')
+		}
+		enc.nested[n-1] = true
+	} else if len(enc.buf) == 0 {
+		enc.writeByte('{')
+	} else {
+		enc.writeByte(',')
+	}
+	enc.buf = append(enc.buf, key...)
+	enc.writeByte(':')
+}
+
+func (enc *encoder) encodeFieldKey(key string) {
 	if isIdent(key) {
 		enc.buf = append(enc.buf, key...)
 	} else {
-		enc.encodeString(key)
+		// keys are never truncated
+		enc.buf = strconv.AppendQuote(enc.buf, key)
 	}
 	enc.writeByte(':')
+	// fields within a redacted value are masked with it
+	if enc.redactOff < 0 && enc.opts != nil && len(enc.opts.redactKeys) > 0 && enc.opts.redacts(key) {
+		// the value is masked lazily by the next encodeKey, endObject or finish
+		enc.redactOff = len(enc.buf)
+		enc.redactDepth = len(enc.nested)
+	}
+}
+
+// beginObject opens a nested object in place, fields encoded by encodeKey
+// go into the object until endObject is called
+func (enc *encoder) beginObject() {
+	enc.writeByte('{')
+	enc.nested = append(enc.nested, false)
+}
+
+// endObject closes the innermost nested object
+func (enc *encoder) endObject() {
+	enc.settle()
+	enc.nested = enc.nested[:len(enc.nested)-1]
+	enc.writeByte('}')
+}
+
+// removeKey removes the encoded field which has the key
+func (enc *encoder) removeKey(key string) {
+	for i := range enc.keys {
+		if enc.keys[i].key != key {
+			continue
+		}
+		begin, end := enc.keys[i].off, len(enc.buf)
+		if i+1 < len(enc.keys) {
+			end = enc.keys[i+1].off
+		}
+		enc.buf = append(enc.buf[:begin], enc.buf[end:]...)
+		if begin == 0 && len(enc.buf) > 0 {
+			// the removed field is the first one, so the next field becomes first
+			enc.buf[0] = '{'
+		}
+		copy(enc.keys[i:], enc.keys[i+1:])
+		enc.keys = enc.keys[:len(enc.keys)-1]
+		for j := i; j < len(enc.keys); j++ {
+			enc.keys[j].off -= end - begin
+		}
+		return
+	}
+}
+
+// appendFields appends top-level fields encoded by another encoder, e.g. base
+// fields of ContextLogger, keys are the recorded keys of the fields. Encoded
+// fields which have the same keys are removed if opts.dedupeKeys.
+func (enc *encoder) appendFields(fields []byte, keys []encodedKey) {
+	if len(fields) == 0 {
+		return
+	}
+	enc.settle()
+	for i := range keys {
+		enc.removeKey(keys[i].key)
+	}
+	base := len(enc.buf)
+	if base > 0 {
+		enc.buf = append(append(enc.buf, ','), fields[1:]...)
+	} else {
+		enc.buf = append(enc.buf, fields...)
+	}
+	for _, k := range keys {
+		enc.keys = append(enc.keys, encodedKey{key: k.key, off: base + k.off})
+	}
 }
 
 func (enc *encoder) finish() {
+	if enc.redactOff >= 0 {
+		// objects opened by the redacted value are masked with it
+		enc.nested = enc.nested[:enc.redactDepth]
+		enc.redact()
+	}
+	if enc.skipOff >= 0 {
+		// objects opened by the skipped value are removed with it
+		enc.nested = enc.nested[:enc.skipDepth]
+		enc.drop()
+	}
+	for range enc.nested {
+		// close objects left open, e.g. Dict's function panicked
+		enc.writeByte('}')
+	}
+	enc.nested = enc.nested[:0]
 	if len(enc.buf) > 0 {
 		enc.buf = append(enc.buf, '}', ' ')
 	}
+	enc.fields = len(enc.buf)
+}
+
+// moveFieldsAfter moves the fields finished by finish after the message which
+// follows them, i.e. "{k:v} message" becomes "message {k:v}". A trailing
+// newline of the message is dropped.
+func (enc *encoder) moveFieldsAfter() {
+	n := enc.fields
+	if n == 0 {
+		return
+	}
+	if len(enc.buf) > n && enc.buf[len(enc.buf)-1] == '\n' {
+		enc.buf = enc.buf[:len(enc.buf)-1]
+	}
+	size := len(enc.buf)
+	if size == n {
+		// no message, drops the trailing space
+		enc.buf = enc.buf[:n-1]
+	} else {
+		enc.buf = append(enc.buf, ' ')
+		enc.buf = append(enc.buf, enc.buf[:n-1]...)
+		enc.buf = enc.buf[:copy(enc.buf, enc.buf[n:])]
+	}
+	enc.fields = 0
+}
+
+// encodeMap encodes m as an object, keys are sorted and encoded by encodeKey
+// so that they're redacted and transformed like keys of fields
+func (enc *encoder) encodeMap(m map[string]interface{}) {
+	if m == nil {
+		enc.encodeNil()
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	enc.beginObject()
+	for _, k := range keys {
+		enc.encodeKey(k)
+		enc.encodeAny(m[k])
+	}
+	enc.endObject()
+}
+
+// encodeAny encodes value by its type, the value is replaced by LogRedact()
+// if it implements Redactor
+func (enc *encoder) encodeAny(value interface{}) {
+	if r, ok := value.(Redactor); ok {
+		value = r.LogRedact()
+	}
+	if value == nil {
+		enc.encodeNil()
+		return
+	}
+	switch x := value.(type) {
+	case error:
+		enc.encodeString(x.Error())
+	case fmt.Stringer:
+		enc.encodeString(x.String())
+	case string:
+		enc.encodeString(x)
+	case appendFormatter:
+		enc.buf = x.AppendFormat(enc.buf)
+	case map[string]interface{}:
+		enc.encodeMap(x)
+	default:
+		if !enc.encodeScalar(value) {
+			enc.encodeString(fmt.Sprintf("%v", value))
+		}
+	}
 }
 
 func (enc *encoder) encodeNil() {
@@ -108,10 +444,54 @@ func (enc *encoder) encodeRune(r rune) {
 	enc.buf = strconv.AppendQuoteRune(enc.buf, r)
 }
 
+// maxFieldLen returns the max number of bytes of string and bytes values,
+// or 0 if there is no limit
+func (enc *encoder) maxFieldLen() int {
+	if enc.opts == nil {
+		return 0
+	}
+	return enc.opts.maxFieldLen
+}
+
+// writeTruncated writes the marker of n truncated bytes
+func (enc *encoder) writeTruncated(n int) {
+	enc.writeString("…(truncated ")
+	enc.buf = strconv.AppendInt(enc.buf, int64(n), 10)
+	enc.writeString(" bytes)")
+}
+
 func (enc *encoder) encodeString(s string) {
+	if max := enc.maxFieldLen(); max > 0 && len(s) > max {
+		// cut at the beginning of a rune to keep valid UTF-8
+		cut := max
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		enc.buf = strconv.AppendQuote(enc.buf, s[:cut])
+		enc.buf = enc.buf[:len(enc.buf)-1]
+		enc.writeTruncated(len(s) - cut)
+		enc.writeByte('"')
+		return
+	}
 	enc.buf = strconv.AppendQuote(enc.buf, s)
 }
 
+func (enc *encoder) encodeTime(t time.Time, layout string) {
+	enc.writeByte('"')
+	enc.buf = t.AppendFormat(enc.buf, layout)
+	enc.writeByte('"')
+}
+
+func (enc *encoder) encodeDuration(d time.Duration) {
+	const reserved = 32
+	l := len(enc.buf)
+	if cap(enc.buf)-l < reserved {
+		enc.grow(reserved)
+	}
+	n := formatDuration(enc.buf[l:l+reserved], d)
+	enc.buf = enc.buf[:l+n]
+}
+
 func (enc *encoder) encodeInt(i int64) {
 	enc.buf = strconv.AppendInt(enc.buf, i, 10)
 }
@@ -121,6 +501,16 @@ func (enc *encoder) encodeUint(i uint64) {
 }
 
 func (enc *encoder) encodeFloat(f float64, bits int) {
+	if enc.opts != nil && enc.opts.nonFinite != NonFiniteLiteral && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		if enc.opts.nonFinite == NonFiniteNil {
+			enc.encodeNil()
+		} else {
+			enc.writeByte('"')
+			enc.buf = strconv.AppendFloat(enc.buf, f, 'f', -1, bits)
+			enc.writeByte('"')
+		}
+		return
+	}
 	enc.buf = strconv.AppendFloat(enc.buf, f, 'f', -1, bits)
 }
 
@@ -161,6 +551,100 @@ func (enc *encoder) encodeComplex128(c complex128) {
 	enc.encodeComplex(r, i, 64)
 }
 
+func (enc *encoder) encodeBytes(s []byte) {
+	encoding := HexBytes
+	if enc.opts != nil {
+		encoding = enc.opts.bytesEncoding
+	}
+	enc.encodeBytesAs(encoding, s)
+}
+
+// encodeBytesAs encodes s with encoding, s is truncated if it's longer than
+// maxFieldLen
+func (enc *encoder) encodeBytesAs(encoding BytesEncoding, s []byte) {
+	var truncated int
+	if max := enc.maxFieldLen(); max > 0 && len(s) > max {
+		truncated = len(s) - max
+		s = s[:max]
+	}
+	switch encoding {
+	case Base64Bytes:
+		enc.encodeBase64(base64.StdEncoding, s)
+	case Base64URLBytes:
+		enc.encodeBase64(base64.URLEncoding, s)
+	default:
+		enc.encodeHexBytes(s)
+	}
+	if truncated > 0 {
+		if encoding == HexBytes {
+			enc.writeTruncated(truncated)
+		} else {
+			// put the marker into the quotes
+			enc.buf = enc.buf[:len(enc.buf)-1]
+			enc.writeTruncated(truncated)
+			enc.writeByte('"')
+		}
+	}
+}
+
+// newTrimPaths normalizes prefixes for trimPath, trailing slashes are
+// removed and longer prefixes go first
+func newTrimPaths(prefixes []string) []string {
+	if len(prefixes) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if prefix = strings.TrimRight(prefix, "/"); prefix != "" {
+			paths = append(paths, prefix)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return len(paths[i]) > len(paths[j])
+	})
+	return paths
+}
+
+// trimPath strips the first matched prefix and the following slash from file
+func trimPath(prefixes []string, file string) string {
+	for _, prefix := range prefixes {
+		if len(file) > len(prefix) && file[len(prefix)] == '/' && strings.HasPrefix(file, prefix) {
+			return file[len(prefix)+1:]
+		}
+	}
+	return file
+}
+
+// isPrintable reports whether b is valid UTF-8 which contains printable
+// characters and whitespaces only
+func isPrintable(b []byte) bool {
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size <= 1 {
+			return false
+		}
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+		b = b[size:]
+	}
+	return true
+}
+
+func (enc *encoder) encodeBase64(encoding *base64.Encoding, s []byte) {
+	var (
+		l = len(enc.buf)
+		n = encoding.EncodedLen(len(s))
+	)
+	if cap(enc.buf)-l < n+2 {
+		enc.grow(n + 2)
+	}
+	enc.buf = enc.buf[:l+n+2]
+	enc.buf[l] = '"'
+	encoding.Encode(enc.buf[l+1:], s)
+	enc.buf[l+n+1] = '"'
+}
+
 func (enc *encoder) encodeScalar(value interface{}) bool {
 	switch x := value.(type) {
 	case int:
@@ -0,0 +1,192 @@
+// Package grpclog routes gRPC logs through a log.Logger. It's a module of its
+// own, so google.golang.org/grpc stays out of the log module.
+//
+// LoggerV2 implements grpclog.LoggerV2 of gRPC:
+//
+//	grpclog.SetLoggerV2(loggrpc.NewLoggerV2(log.Default(), 0))
+//
+// Interceptors log finished calls:
+//
+//	server := grpc.NewServer(
+//		grpc.UnaryInterceptor(loggrpc.UnaryServerInterceptor(log.Default(), nil)),
+//		grpc.StreamInterceptor(loggrpc.StreamServerInterceptor(log.Default(), nil)),
+//	)
+//	conn, err := grpc.Dial(target,
+//		grpc.WithUnaryInterceptor(loggrpc.UnaryClientInterceptor(log.Default(), nil)),
+//		grpc.WithStreamInterceptor(loggrpc.StreamClientInterceptor(log.Default(), nil)),
+//	)
+package grpclog
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gopherd/log"
+	"google.golang.org/grpc"
+	ggrpclog "google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/status"
+)
+
+// LogCall logs a finished call of method with status code, calls succeeded
+// (err is nil) are logged at level info, others at level error.
+func LogCall(l *log.Logger, method, code string, start time.Time, err error) {
+	logCall(l, "call", "", method, code, start, err)
+}
+
+func logCall(l *log.Logger, msg, kind, method, code string, start time.Time, err error) {
+	level := log.LevelInfo
+	if err != nil {
+		level = log.LevelError
+	}
+	ctx := l.Log(level).String("method", method)
+	if kind != "" {
+		ctx.String("kind", kind)
+	}
+	ctx.String("code", code).Duration("elapsed", time.Since(start))
+	if err != nil {
+		ctx.Error("error", err)
+	}
+	ctx.Print(msg)
+}
+
+// CodeFunc returns the status code of err, e.g. to name custom codes
+type CodeFunc func(err error) string
+
+// defaultCode is used if CodeFunc is nil
+func defaultCode(err error) string {
+	return status.Code(err).String()
+}
+
+// interceptor calls call, which calls the handler, the invoker or the
+// streamer of gRPC, and logs it as a finished call of method
+type interceptor func(method string, call func() error) error
+
+func newInterceptor(l *log.Logger, codeOf CodeFunc, msg, kind string) interceptor {
+	if codeOf == nil {
+		codeOf = defaultCode
+	}
+	return func(method string, call func() error) error {
+		start := time.Now()
+		err := call()
+		logCall(l, msg, kind, method, codeOf(err), start, err)
+		return err
+	}
+}
+
+// UnaryServerInterceptor returns an interceptor which logs unary calls
+// handled by the server, status codes are got by codeOf if it's not nil.
+func UnaryServerInterceptor(l *log.Logger, codeOf CodeFunc) grpc.UnaryServerInterceptor {
+	intercept := newInterceptor(l, codeOf, "call", "unary")
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		err = intercept(info.FullMethod, func() error {
+			resp, err = handler(ctx, req)
+			return err
+		})
+		return
+	}
+}
+
+// StreamServerInterceptor returns an interceptor which logs streams handled
+// by the server once they're finished, status codes are got by codeOf if it's
+// not nil.
+func StreamServerInterceptor(l *log.Logger, codeOf CodeFunc) grpc.StreamServerInterceptor {
+	intercept := newInterceptor(l, codeOf, "call", "stream")
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return intercept(info.FullMethod, func() error { return handler(srv, ss) })
+	}
+}
+
+// UnaryClientInterceptor returns an interceptor which logs unary calls
+// invoked by the client, status codes are got by codeOf if it's not nil.
+func UnaryClientInterceptor(l *log.Logger, codeOf CodeFunc) grpc.UnaryClientInterceptor {
+	intercept := newInterceptor(l, codeOf, "client call", "unary")
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return intercept(method, func() error { return invoker(ctx, method, req, reply, cc, opts...) })
+	}
+}
+
+// StreamClientInterceptor returns an interceptor which logs streams opened by
+// the client, the elapsed time is the time of opening the stream since gRPC
+// doesn't report when the client stream is finished. Status codes are got by
+// codeOf if it's not nil.
+func StreamClientInterceptor(l *log.Logger, codeOf CodeFunc) grpc.StreamClientInterceptor {
+	intercept := newInterceptor(l, codeOf, "client call", "stream")
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (cs grpc.ClientStream, err error) {
+		err = intercept(method, func() error {
+			cs, err = streamer(ctx, desc, cc, method, opts...)
+			return err
+		})
+		return
+	}
+}
+
+var _ ggrpclog.LoggerV2 = (*LoggerV2)(nil)
+
+// LoggerV2 implements grpclog.LoggerV2 of gRPC by a log.Logger, severities
+// info, warning, error and fatal are mapped to levels of the same names.
+type LoggerV2 struct {
+	logger    *log.Logger
+	verbosity int
+}
+
+// NewLoggerV2 creates a LoggerV2, V(l) reports true if l <= verbosity
+func NewLoggerV2(l *log.Logger, verbosity int) *LoggerV2 {
+	return &LoggerV2{logger: l, verbosity: verbosity}
+}
+
+func (l *LoggerV2) print(level log.Level, msg string) {
+	l.logger.Print(2, level, msg)
+}
+
+// Info logs to info level
+func (l *LoggerV2) Info(args ...interface{}) { l.print(log.LevelInfo, fmt.Sprint(args...)) }
+
+// Infoln logs to info level
+func (l *LoggerV2) Infoln(args ...interface{}) { l.print(log.LevelInfo, sprintln(args)) }
+
+// Infof logs to info level
+func (l *LoggerV2) Infof(format string, args ...interface{}) {
+	l.print(log.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+// Warning logs to warn level
+func (l *LoggerV2) Warning(args ...interface{}) { l.print(log.LevelWarn, fmt.Sprint(args...)) }
+
+// Warningln logs to warn level
+func (l *LoggerV2) Warningln(args ...interface{}) { l.print(log.LevelWarn, sprintln(args)) }
+
+// Warningf logs to warn level
+func (l *LoggerV2) Warningf(format string, args ...interface{}) {
+	l.print(log.LevelWarn, fmt.Sprintf(format, args...))
+}
+
+// Error logs to error level
+func (l *LoggerV2) Error(args ...interface{}) { l.print(log.LevelError, fmt.Sprint(args...)) }
+
+// Errorln logs to error level
+func (l *LoggerV2) Errorln(args ...interface{}) { l.print(log.LevelError, sprintln(args)) }
+
+// Errorf logs to error level
+func (l *LoggerV2) Errorf(format string, args ...interface{}) {
+	l.print(log.LevelError, fmt.Sprintf(format, args...))
+}
+
+// Fatal logs to fatal level and exits
+func (l *LoggerV2) Fatal(args ...interface{}) { l.print(log.LevelFatal, fmt.Sprint(args...)) }
+
+// Fatalln logs to fatal level and exits
+func (l *LoggerV2) Fatalln(args ...interface{}) { l.print(log.LevelFatal, sprintln(args)) }
+
+// Fatalf logs to fatal level and exits
+func (l *LoggerV2) Fatalf(format string, args ...interface{}) {
+	l.print(log.LevelFatal, fmt.Sprintf(format, args...))
+}
+
+// V reports whether verbosity level l is at least the requested verbose level
+func (l *LoggerV2) V(level int) bool { return level <= l.verbosity }
+
+func sprintln(args []interface{}) string {
+	s := fmt.Sprintln(args...)
+	return s[:len(s)-1]
+}
@@ -0,0 +1,102 @@
+package grpclog_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopherd/log"
+	"github.com/gopherd/log/wrapper/grpclog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoggerV2(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewLogger("")
+	logger.Start(log.WithOutput(&buf), log.WithSync(true), log.WithFlags(0), log.WithLevel(log.LevelInfo))
+	l := grpclog.NewLoggerV2(logger, 1)
+	l.Infoln("a", "b")
+	l.Warningf("x=%d", 1)
+	l.Error("e")
+	grpclog.LogCall(logger, "/pkg.Service/Ok", "OK", time.Now(), nil)
+	grpclog.LogCall(logger, "/pkg.Service/Fail", "Internal", time.Now(), errors.New("boom"))
+	logger.Shutdown()
+
+	out := buf.String()
+	for _, want := range []string{
+		"[I] a b\n",
+		"[W] x=1\n",
+		"[E] e\n",
+		`[I] {method:"/pkg.Service/Ok",code:"OK",`,
+		`[E] {method:"/pkg.Service/Fail",code:"Internal",`,
+		`error:"boom"} call`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q does not contain %q", out, want)
+		}
+	}
+	if !l.V(1) || l.V(2) {
+		t.Errorf("unexpected verbosity")
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewLogger("")
+	logger.Start(log.WithOutput(&buf), log.WithSync(true), log.WithFlags(0))
+	defer logger.Shutdown()
+	var (
+		ctx    = context.Background()
+		failed = status.Error(codes.Internal, "boom")
+		codeOf = func(err error) string {
+			if err != nil {
+				return "Failed"
+			}
+			return "Done"
+		}
+	)
+	for _, tc := range []struct {
+		intercept func(err error) error
+		err       error
+		want      string
+	}{
+		{func(err error) error {
+			resp, got := grpclog.UnaryServerInterceptor(logger, nil)(ctx, "req", &grpc.UnaryServerInfo{FullMethod: "/pkg.S/M"},
+				func(ctx context.Context, req interface{}) (interface{}, error) { return "resp", err })
+			if resp != "resp" {
+				t.Errorf("want response resp, but got %v", resp)
+			}
+			return got
+		}, nil, `[I] {method:"/pkg.S/M",kind:"unary",code:"OK",elapsed:`},
+		{func(err error) error {
+			return grpclog.StreamServerInterceptor(logger, nil)(nil, nil, &grpc.StreamServerInfo{FullMethod: "/pkg.S/M"},
+				func(srv interface{}, ss grpc.ServerStream) error { return err })
+		}, failed, `[E] {method:"/pkg.S/M",kind:"stream",code:"Internal",elapsed:`},
+		{func(err error) error {
+			return grpclog.UnaryClientInterceptor(logger, codeOf)(ctx, "/pkg.S/M", "req", nil, nil,
+				func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+					return err
+				})
+		}, errors.New("boom"), `[E] {method:"/pkg.S/M",kind:"unary",code:"Failed",elapsed:`},
+		{func(err error) error {
+			_, got := grpclog.StreamClientInterceptor(logger, codeOf)(ctx, &grpc.StreamDesc{}, nil, "/pkg.S/M",
+				func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+					return nil, err
+				})
+			return got
+		}, nil, `[I] {method:"/pkg.S/M",kind:"stream",code:"Done",elapsed:`},
+	} {
+		buf.Reset()
+		if err := tc.intercept(tc.err); err != tc.err {
+			t.Errorf("want call returned %v, but got %v", tc.err, err)
+		}
+		if got := buf.String(); !strings.HasPrefix(got, tc.want) {
+			t.Errorf("want prefix %q, but got %q", tc.want, got)
+		}
+	}
+}
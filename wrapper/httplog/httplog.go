@@ -0,0 +1,134 @@
+// Package httplog provides an HTTP middleware which logs requests by a
+// log.Logger, e.g.
+//
+//	http.ListenAndServe(":8080", httplog.Handler(mux, log.Default()))
+//
+// Entries are prefixed with the request id and look like:
+//
+//	[I] (5f2b9c1e0a7d4b3c) {method:"GET",path:"/users",status:200,bytes:42,elapsed:1.2ms} request
+package httplog
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gopherd/log"
+)
+
+// RequestIDHeader is the header of request id, the request id is generated
+// if the request has no such header, and it's set to the response header.
+const RequestIDHeader = "X-Request-Id"
+
+type contextKey struct{}
+
+// Logger returns the context logger of the request handled by Handler, the
+// prefix of the context logger is the request id. The default logger is
+// returned if ctx is not a request context of Handler.
+func Logger(ctx context.Context) *log.ContextLogger {
+	if l, ok := ctx.Value(contextKey{}).(*log.ContextLogger); ok {
+		return l
+	}
+	return log.Default().WithPrefix("")
+}
+
+// Handler returns a handler which logs method, path, status, bytes and
+// latency of requests served by next. Requests whose status >= 500 are
+// logged at level error, others at level info. Panics of next are recovered
+// and logged at level error with the stack.
+func Handler(next http.Handler, l *log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		var (
+			start  = time.Now()
+			logger = l.WithPrefix(id)
+			rw     = &responseWriter{ResponseWriter: w}
+		)
+		defer func() {
+			if e := recover(); e != nil {
+				if e == http.ErrAbortHandler {
+					panic(e)
+				}
+				logger.Error().Any("panic", e).Stack("stack").Print("panic")
+				if rw.status == 0 {
+					rw.WriteHeader(http.StatusInternalServerError)
+				}
+			}
+			level := log.LevelInfo
+			if rw.status >= http.StatusInternalServerError {
+				level = log.LevelError
+			}
+			logger.Log(level).
+				String("method", r.Method).
+				String("path", r.URL.Path).
+				Int("status", rw.statusCode()).
+				Int64("bytes", rw.written).
+				Duration("elapsed", time.Since(start)).
+				Print("request")
+		}()
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), contextKey{}, logger)))
+	})
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// responseWriter records status and number of written bytes
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *responseWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// WriteHeader implements http.ResponseWriter WriteHeader method
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter Write method
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher Flush method
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker Hijack method
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("httplog: response writer does not implement http.Hijacker")
+}
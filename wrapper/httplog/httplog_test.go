@@ -0,0 +1,53 @@
+package httplog_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gopherd/log"
+	"github.com/gopherd/log/wrapper/httplog"
+)
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewLogger("")
+	logger.Start(log.WithOutput(&buf), log.WithSync(true), log.WithFlags(0))
+	handler := httplog.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/panic":
+			panic("boom")
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			httplog.Logger(r.Context()).Info().Print("hello")
+			w.Write([]byte("hello"))
+		}
+	}), logger)
+
+	for _, path := range []string{"/hello", "/missing", "/panic"} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set(httplog.RequestIDHeader, "id")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if got := w.Header().Get(httplog.RequestIDHeader); got != "id" {
+			t.Errorf("%s: want request id %q, but got %q", path, "id", got)
+		}
+	}
+	logger.Shutdown()
+
+	lines := strings.Split(buf.String(), "\n")
+	for i, want := range []string{
+		`[I] (id) hello`,
+		`[I] (id) {method:"GET",path:"/hello",status:200,bytes:5,elapsed:`,
+		`[I] (id) {method:"GET",path:"/missing",status:404,bytes:19,elapsed:`,
+		`[E] (id) {panic:"boom",stack:[`,
+		`[E] (id) {method:"GET",path:"/panic",status:500,bytes:0,elapsed:`,
+	} {
+		if i >= len(lines) || !strings.HasPrefix(lines[i], want) {
+			t.Errorf("line %d: want prefix %q, but got %q", i, want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Package kafkalog provides a writer which produces one Kafka message per log
+// entry. It doesn't depend on any Kafka client, the client is plugged in by a
+// Producer adapter, so that the log module stays dependency-free, e.g.
+//
+//	kafkalog.Register(func(brokers []string) (kafkalog.Producer, error) {
+//		return newSaramaProducer(brokers) // an adapter of the client
+//	})
+//	w, err := log.Open("kafka:broker1:9092,broker2:9092?topic=logs&key=level")
+//
+// Entries are queued and produced by a goroutine, see log.AsyncWriter.
+package kafkalog
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gopherd/log"
+)
+
+// DefaultQueueSize is the default max number of queued entries
+const DefaultQueueSize = 4096
+
+// Producer is implemented by adapters of Kafka clients
+type Producer interface {
+	// Produce produces a message, value is not available after Produce returned
+	Produce(topic string, key, value []byte) error
+	// Close flushes pending messages and closes the producer
+	Close() error
+}
+
+// Dialer creates a producer connected to the brokers
+type Dialer func(brokers []string) (Producer, error)
+
+// Register registers the "kafka" writer which dials producers by dial, the
+// source of url is formatted like
+//
+//	broker1:9092[,broker2:9092...]?topic=logs[&key=level|none][&queue=4096]
+//
+// Messages are keyed by level by default, key=none produces messages without key.
+func Register(dial Dialer) {
+	if dial == nil {
+		panic("kafkalog: Register with a nil dialer")
+	}
+	log.Register("kafka", func(source string) (log.Writer, error) {
+		return open(dial, source)
+	})
+}
+
+func open(dial Dialer, source string) (log.Writer, error) {
+	var (
+		addrs = source
+		query string
+	)
+	if i := strings.Index(source, "?"); i >= 0 {
+		addrs, query = source[:i], source[i+1:]
+	}
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, errors.New("kafkalog: invalid source " + strconv.Quote(source))
+	}
+	var brokers []string
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			brokers = append(brokers, addr)
+		}
+	}
+	if len(brokers) == 0 {
+		return nil, errors.New("kafkalog: no brokers in source " + strconv.Quote(source))
+	}
+	topic := q.Get("topic")
+	if topic == "" {
+		return nil, errors.New("kafkalog: no topic in source " + strconv.Quote(source))
+	}
+	var keyed bool
+	switch key := q.Get("key"); key {
+	case "", "level":
+		keyed = true
+	case "none":
+	default:
+		return nil, errors.New("kafkalog: invalid key " + strconv.Quote(key))
+	}
+	queueSize := DefaultQueueSize
+	if s := q.Get("queue"); s != "" {
+		if queueSize, err = strconv.Atoi(s); err != nil || queueSize <= 0 {
+			return nil, errors.New("kafkalog: invalid queue " + strconv.Quote(s))
+		}
+	}
+	p, err := dial(brokers)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(p, topic, keyed, queueSize), nil
+}
+
+// NewWriter creates a writer which produces entries to topic by p, messages
+// are keyed by level if keyed is true. Entries are dropped if more than
+// queueSize entries are queued, see log.AsyncWriter.Dropped. The producer is
+// closed by Close of the writer after queued entries produced.
+func NewWriter(p Producer, topic string, keyed bool, queueSize int) *log.AsyncWriter {
+	if p == nil {
+		panic("kafkalog: NewWriter with a nil producer")
+	}
+	return log.NewAsyncWriter(&writer{
+		producer: p,
+		topic:    topic,
+		keyed:    keyed,
+	}, queueSize)
+}
+
+// writer produces entries without header
+type writer struct {
+	producer Producer
+	topic    string
+	keyed    bool
+}
+
+var levelKeys [8][]byte
+
+func init() {
+	for i := range levelKeys {
+		levelKeys[i] = []byte(log.Level(i).String())
+	}
+}
+
+// StripHeader implements log.HeaderAware StripHeader method
+func (w *writer) StripHeader() bool { return true }
+
+// Write implements log.Writer Write method
+func (w *writer) Write(level log.Level, data []byte, _ int) error {
+	var key []byte
+	if w.keyed {
+		if int(level) >= 0 && int(level) < len(levelKeys) {
+			key = levelKeys[level]
+		} else {
+			key = []byte(level.String())
+		}
+	}
+	return w.producer.Produce(w.topic, key, data)
+}
+
+// Close implements log.Writer Close method
+func (w *writer) Close() error { return w.producer.Close() }
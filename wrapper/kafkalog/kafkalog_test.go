@@ -0,0 +1,69 @@
+package kafkalog_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gopherd/log"
+	"github.com/gopherd/log/wrapper/kafkalog"
+)
+
+type message struct {
+	topic, key, value string
+}
+
+type testProducer struct {
+	brokers  []string
+	messages []message
+	closed   bool
+}
+
+func (p *testProducer) Produce(topic string, key, value []byte) error {
+	p.messages = append(p.messages, message{topic, string(key), string(value)})
+	return nil
+}
+
+func (p *testProducer) Close() error {
+	p.closed = true
+	return nil
+}
+
+var producer *testProducer
+
+func init() {
+	kafkalog.Register(func(brokers []string) (kafkalog.Producer, error) {
+		producer = &testProducer{brokers: brokers}
+		return producer, nil
+	})
+}
+
+func TestWriter(t *testing.T) {
+	for _, source := range []string{"", "a:9092", "a:9092?topic=logs&key=x", "a:9092?topic=logs&queue=0"} {
+		if _, err := log.Open("kafka:" + source); err == nil {
+			t.Errorf("source %q: want an error", source)
+		}
+	}
+	w, err := log.Open("kafka:a:9092,b:9092?topic=logs")
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	logger := log.NewLogger("")
+	logger.Start(log.WithWriters(w), log.WithSync(true))
+	logger.Info().String("k", "v").Print("hello")
+	logger.Warn().Print("world")
+	logger.Shutdown()
+
+	if want := []string{"a:9092", "b:9092"}; !reflect.DeepEqual(producer.brokers, want) {
+		t.Errorf("want brokers %v, but got %v", want, producer.brokers)
+	}
+	want := []message{
+		{"logs", "INFO", "{k:\"v\"} hello\n"},
+		{"logs", "WARN", "world\n"},
+	}
+	if !reflect.DeepEqual(producer.messages, want) {
+		t.Errorf("want messages %v, but got %v", want, producer.messages)
+	}
+	if !producer.closed {
+		t.Error("producer not closed")
+	}
+}
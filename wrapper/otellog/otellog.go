@@ -0,0 +1,177 @@
+// Package otellog provides a writer which converts entries to OpenTelemetry
+// log records. It doesn't depend on the OpenTelemetry SDK, records are
+// exported by an Exporter adapter, so that the log module stays
+// dependency-free, e.g.
+//
+//	w := otellog.NewWriter(newSDKExporter(provider.Logger("app"))) // an adapter of the SDK
+//	log.Start(log.WithWriters(w))
+//
+// The writer implements log.StructuredWriter, fields put by log.Context are
+// converted to typed attributes from log.Record.Fields without parsing the
+// formatted entry.
+package otellog
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gopherd/log"
+)
+
+// Severity numbers defined by the OpenTelemetry log data model
+const (
+	SeverityTrace = 1
+	SeverityDebug = 5
+	SeverityInfo  = 9
+	SeverityWarn  = 13
+	SeverityError = 17
+	SeverityFatal = 21
+)
+
+// Keys of attributes which are set from the caller, the prefix, fields
+// which couldn't be decoded and the stack of fatal records
+const (
+	KeyFilepath   = "code.filepath"
+	KeyLineno     = "code.lineno"
+	KeyFunction   = "code.function"
+	KeyPrefix     = "log.prefix"
+	KeyFields     = "log.fields"
+	KeyStacktrace = "code.stacktrace"
+)
+
+// Keys of fields which are used as trace context of records, see
+// log.Context.TraceID and log.Context.SpanID
+const (
+	KeyTraceID = log.TraceIDKey
+	KeySpanID  = log.SpanIDKey
+)
+
+// Attribute represents an attribute of a record. Value of a field is decoded
+// by log.ParseFields, e.g. a string, an int64, []interface{} for arrays or
+// []log.Field for objects.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Record represents an OpenTelemetry log record
+type Record struct {
+	Timestamp      time.Time
+	SeverityNumber int
+	SeverityText   string
+	Body           string
+	TraceID        string // hex-encoded, or empty
+	SpanID         string // hex-encoded, or empty
+	Attributes     []Attribute
+}
+
+// Exporter is implemented by adapters of the OpenTelemetry SDK
+type Exporter interface {
+	// Export exports a record, r is not available after Export returned
+	Export(r *Record) error
+	// Shutdown flushes pending records and shuts down the exporter
+	Shutdown() error
+}
+
+// Severity returns the severity number of level
+func Severity(level log.Level) int {
+	switch level {
+	case log.LevelFatal:
+		return SeverityFatal
+	case log.LevelError:
+		return SeverityError
+	case log.LevelWarn:
+		return SeverityWarn
+	case log.LevelInfo:
+		return SeverityInfo
+	case log.LevelDebug:
+		return SeverityDebug
+	case log.LevelTrace:
+		return SeverityTrace
+	}
+	return 0
+}
+
+// Writer exports entries by an exporter
+type Writer struct {
+	exporter Exporter
+	record   Record
+}
+
+// NewWriter creates a writer which exports entries by e. The exporter is
+// shut down by Close of the writer.
+func NewWriter(e Exporter) *Writer {
+	if e == nil {
+		panic("otellog: NewWriter with a nil exporter")
+	}
+	return &Writer{exporter: e}
+}
+
+// StripHeader implements log.HeaderAware StripHeader method
+func (w *Writer) StripHeader() bool { return true }
+
+// Write implements log.Writer Write method, it's called only if the writer
+// is wrapped by other writers, e.g. log.AsyncWriter, so the record has a body
+// but no attributes.
+func (w *Writer) Write(level log.Level, data []byte, _ int) error {
+	r := w.reset(level, time.Now())
+	if n := len(data); n > 0 && data[n-1] == '\n' {
+		data = data[:n-1]
+	}
+	r.Body = string(data)
+	return w.exporter.Export(r)
+}
+
+// WriteRecord implements log.StructuredWriter WriteRecord method
+func (w *Writer) WriteRecord(lr *log.Record) error {
+	r := w.reset(lr.Level, lr.Time)
+	r.Body = lr.Message
+	if n := len(r.Body); n > 0 && r.Body[n-1] == '\n' {
+		r.Body = r.Body[:n-1]
+	}
+	if lr.Caller.Line > 0 {
+		r.Attributes = append(r.Attributes,
+			Attribute{KeyFilepath, lr.Caller.Filename},
+			Attribute{KeyLineno, int64(lr.Caller.Line)},
+		)
+		if fn := lr.Caller.Func(); fn != "" {
+			r.Attributes = append(r.Attributes, Attribute{KeyFunction, fn})
+		}
+	}
+	if lr.Prefix != "" {
+		r.Attributes = append(r.Attributes, Attribute{KeyPrefix, lr.Prefix})
+	}
+	if lr.Fields == nil && lr.RawFields != "" {
+		// keeps malformed fields, e.g. put by an invalid log.Context.RawJSON
+		r.Attributes = append(r.Attributes, Attribute{KeyFields, lr.RawFields})
+	}
+	for _, f := range lr.Fields {
+		r.Attributes = append(r.Attributes, Attribute{f.Key, f.Value})
+	}
+	if len(lr.Stack) > 0 {
+		r.Attributes = append(r.Attributes, Attribute{KeyStacktrace, strings.Join(lr.Stack, "\n")})
+	}
+	for _, a := range r.Attributes {
+		switch a.Key {
+		case KeyTraceID:
+			r.TraceID, _ = a.Value.(string)
+		case KeySpanID:
+			r.SpanID, _ = a.Value.(string)
+		}
+	}
+	return w.exporter.Export(r)
+}
+
+// Close implements log.Writer Close method
+func (w *Writer) Close() error { return w.exporter.Shutdown() }
+
+// reset resets the reused record, writes are serialized by the provider
+func (w *Writer) reset(level log.Level, t time.Time) *Record {
+	w.record = Record{
+		Timestamp:      t,
+		SeverityNumber: Severity(level),
+		SeverityText:   level.String(),
+		Attributes:     w.record.Attributes[:0],
+	}
+	return &w.record
+}
@@ -0,0 +1,136 @@
+package otellog_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gopherd/log"
+	"github.com/gopherd/log/wrapper/otellog"
+)
+
+type testExporter struct {
+	records []otellog.Record
+	closed  bool
+}
+
+func (e *testExporter) Export(r *otellog.Record) error {
+	copied := *r
+	copied.Attributes = append([]otellog.Attribute(nil), r.Attributes...)
+	copied.Timestamp = copied.Timestamp.UTC()
+	e.records = append(e.records, copied)
+	return nil
+}
+
+func (e *testExporter) Shutdown() error {
+	e.closed = true
+	return nil
+}
+
+func TestWriter(t *testing.T) {
+	exporter := new(testExporter)
+	logger := log.NewLogger("")
+	logger.Start(log.WithWriters(otellog.NewWriter(exporter)), log.WithSync(true), log.WithFlags(0))
+	logger.Info().
+		String("trace_id", "0af7651916cd43dd8448eb211c80319c").
+		String("span_id", "b7ad6b7169203331").
+		String("a b", "x,y}").
+		Ints("ids", []int{1, 2}).
+		Dict("user", func(ctx *log.Context) { ctx.Int("id", 1) }).
+		Print("hello")
+	logger.WithPrefix("db").Error().Print("failed")
+	logger.Shutdown()
+
+	if !exporter.closed {
+		t.Error("exporter not shut down")
+	}
+	if len(exporter.records) != 2 {
+		t.Fatalf("want 2 records, but got %d", len(exporter.records))
+	}
+	r := exporter.records[0]
+	if r.SeverityNumber != otellog.SeverityInfo || r.SeverityText != "INFO" || r.Body != "hello" {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if r.TraceID != "0af7651916cd43dd8448eb211c80319c" || r.SpanID != "b7ad6b7169203331" {
+		t.Errorf("unexpected trace context %q, %q", r.TraceID, r.SpanID)
+	}
+	want := []otellog.Attribute{
+		{"trace_id", "0af7651916cd43dd8448eb211c80319c"},
+		{"span_id", "b7ad6b7169203331"},
+		{"a b", "x,y}"},
+		{"ids", []interface{}{int64(1), int64(2)}},
+		{"user", []log.Field{{Key: "id", Value: int64(1)}}},
+	}
+	if !reflect.DeepEqual(r.Attributes, want) {
+		t.Errorf("want attributes %v, but got %v", want, r.Attributes)
+	}
+	r = exporter.records[1]
+	want = []otellog.Attribute{{otellog.KeyPrefix, "db"}}
+	if r.SeverityNumber != otellog.SeverityError || r.Body != "failed" || !reflect.DeepEqual(r.Attributes, want) {
+		t.Errorf("unexpected record %+v", r)
+	}
+}
+
+func TestWriterAttributes(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		put   func(ctx *log.Context) *log.Context
+		attrs []otellog.Attribute
+	}{
+		{"open brace rune", func(ctx *log.Context) *log.Context {
+			return ctx.Rune("r", '{').Int("n", 1)
+		}, []otellog.Attribute{{"r", "{"}, {"n", int64(1)}}},
+		{"comma rune", func(ctx *log.Context) *log.Context {
+			return ctx.Rune("r", ',').Int("n", 1)
+		}, []otellog.Attribute{{"r", ","}, {"n", int64(1)}}},
+		{"quote byte", func(ctx *log.Context) *log.Context {
+			return ctx.Byte("b", '\'').Rune("r", '\'').Bool("ok", true)
+		}, []otellog.Attribute{{"b", "'"}, {"r", "'"}, {"ok", true}}},
+		{"string", func(ctx *log.Context) *log.Context {
+			return ctx.String("s", `{"a":1},[b]`).String("t", `\"'`)
+		}, []otellog.Attribute{{"s", `{"a":1},[b]`}, {"t", `\"'`}}},
+		{"nested", func(ctx *log.Context) *log.Context {
+			return ctx.Dict("d", func(ctx *log.Context) { ctx.String("k", "},{") }).Float64("f", 1.5)
+		}, []otellog.Attribute{{"d", []log.Field{{Key: "k", Value: "},{"}}}, {"f", 1.5}}},
+		{"invalid raw json", func(ctx *log.Context) *log.Context {
+			return ctx.RawJSON("j", []byte(`{"a"`))
+		}, []otellog.Attribute{{otellog.KeyFields, `{j:{"a"}`}}},
+	} {
+		exporter := new(testExporter)
+		logger := log.NewLogger("")
+		logger.Start(log.WithWriters(otellog.NewWriter(exporter)), log.WithSync(true), log.WithFlags(0))
+		tc.put(logger.Info()).Print("hello")
+		logger.Shutdown()
+
+		if len(exporter.records) != 1 {
+			t.Errorf("%s: want 1 record, but got %d", tc.name, len(exporter.records))
+		} else if r := exporter.records[0]; r.Body != "hello" || !reflect.DeepEqual(r.Attributes, tc.attrs) {
+			t.Errorf("%s: want attributes %#v, but got %#v", tc.name, tc.attrs, r.Attributes)
+		}
+	}
+}
+
+func TestWriterFatal(t *testing.T) {
+	exporter := new(testExporter)
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithWriters(otellog.NewWriter(exporter)),
+		log.WithSync(true),
+		log.WithFlags(0),
+		log.WithExitFunc(func(int) {}),
+	)
+	logger.Fatal().Int("n", 1).Print("fatal")
+	logger.Shutdown()
+
+	if len(exporter.records) != 1 {
+		t.Fatalf("want 1 record, but got %d", len(exporter.records))
+	}
+	r := exporter.records[0]
+	if r.SeverityNumber != otellog.SeverityFatal || r.Body != "fatal" || len(r.Attributes) != 2 ||
+		r.Attributes[0] != (otellog.Attribute{Key: "n", Value: int64(1)}) || r.Attributes[1].Key != otellog.KeyStacktrace {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if stack, _ := r.Attributes[1].Value.(string); !strings.Contains(stack, "otellog_test.TestWriterFatal ") {
+		t.Errorf("want the stack of the caller, but got %q", stack)
+	}
+}
@@ -0,0 +1,37 @@
+// Package promlog counts log entries by level with prometheus counters
+// without importing github.com/prometheus/client_golang, so the dependency
+// stays out of this module, e.g.
+//
+//	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+//		Name: "log_entries_total",
+//		Help: "Number of log entries by level.",
+//	}, []string{"level"})
+//	prometheus.MustRegister(counter)
+//	log.Start(log.WithLevelCounter(promlog.LevelCounter(func(level string) promlog.Counter {
+//		return counter.WithLabelValues(level)
+//	})))
+package promlog
+
+import (
+	"github.com/gopherd/log"
+)
+
+// Counter is implemented by prometheus.Counter
+type Counter interface {
+	Inc()
+}
+
+// LevelCounter returns a function for log.WithLevelCounter which increments
+// the counter of level. Counters are created by counterOf once for every
+// level up front, so counting an entry doesn't look up labels.
+func LevelCounter(counterOf func(level string) Counter) func(log.Level) {
+	var counters [log.LevelTrace + 1]Counter
+	for level := log.LevelFatal; level <= log.LevelTrace; level++ {
+		counters[level] = counterOf(level.String())
+	}
+	return func(level log.Level) {
+		if level >= log.LevelFatal && level <= log.LevelTrace {
+			counters[level].Inc()
+		}
+	}
+}
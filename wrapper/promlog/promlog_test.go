@@ -0,0 +1,39 @@
+package promlog_test
+
+import (
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gopherd/log"
+	"github.com/gopherd/log/wrapper/promlog"
+)
+
+type counter struct{ n int64 }
+
+func (c *counter) Inc() { atomic.AddInt64(&c.n, 1) }
+
+func TestLevelCounter(t *testing.T) {
+	counters := make(map[string]*counter)
+	fn := promlog.LevelCounter(func(level string) promlog.Counter {
+		c := new(counter)
+		counters[level] = c
+		return c
+	})
+	logger := log.NewLogger("")
+	logger.Start(log.WithOutput(ioutil.Discard), log.WithLevel(log.LevelDebug), log.WithLevelCounter(fn))
+	logger.Info().Print("info")
+	logger.Warn().Print("warn")
+	logger.Info().Print("info")
+	logger.Trace().Print("trace")
+	logger.Shutdown()
+	for level, want := range map[log.Level]int64{
+		log.LevelInfo:  2,
+		log.LevelWarn:  1,
+		log.LevelTrace: 0,
+	} {
+		if got := atomic.LoadInt64(&counters[level.String()].n); got != want {
+			t.Errorf("want %d entries of level %v, but got %d", want, level, got)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+// Package sqllog wraps database/sql drivers to log queries, arguments and
+// latencies by a log.Logger, e.g.
+//
+//	connector := sqllog.Wrap(mysqlConnector, log.Default())
+//	db := sql.OpenDB(connector)
+//
+// Entries look like:
+//
+//	[D] {query:"SELECT * FROM users WHERE id=?",args:["1"],elapsed:1.2ms} query
+package sqllog
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/gopherd/log"
+)
+
+// Option represents options of the wrapper
+type Option func(*options)
+
+type options struct {
+	level    log.Level
+	hideArgs bool
+}
+
+// WithLevel sets the level of entries (default: LevelDebug)
+func WithLevel(level log.Level) Option {
+	return func(opt *options) {
+		opt.level = level
+	}
+}
+
+// HideArgs masks all arguments of queries, it's useful if arguments contain
+// secrets. Arguments which implement log.Redactor are logged by LogRedact()
+// without this option, so that only secrets are masked.
+func HideArgs() Option {
+	return func(opt *options) {
+		opt.hideArgs = true
+	}
+}
+
+// logger logs queries
+type logger struct {
+	logger *log.Logger
+	options
+}
+
+func (l *logger) log(op, query string, args []driver.NamedValue, start time.Time, err error) {
+	if err == driver.ErrSkip {
+		return
+	}
+	ctx := l.logger.Log(l.level)
+	if ctx == nil {
+		return
+	}
+	if query != "" {
+		ctx.String("query", query)
+	}
+	if len(args) > 0 {
+		ctx.Strs("args", len(args), func(i int) string {
+			if l.hideArgs {
+				return "******"
+			}
+			switch v := args[i].Value.(type) {
+			case redactedArg:
+				return fmt.Sprint(v.redacted)
+			case log.Redactor:
+				return fmt.Sprint(v.LogRedact())
+			}
+			return fmt.Sprint(args[i].Value)
+		})
+	}
+	ctx.Duration("elapsed", time.Since(start))
+	if err != nil {
+		ctx.Error("error", err)
+	}
+	ctx.Print(op)
+}
+
+// Wrap wraps the connector so that queries executed by its connections are
+// logged by logger
+func Wrap(connector driver.Connector, l *log.Logger, opts ...Option) driver.Connector {
+	c := &wrappedConnector{
+		connector: connector,
+		logger:    &logger{logger: l, options: options{level: log.LevelDebug}},
+	}
+	for _, opt := range opts {
+		opt(&c.logger.options)
+	}
+	return c
+}
+
+type wrappedConnector struct {
+	connector driver.Connector
+	logger    *logger
+}
+
+// Connect implements driver.Connector Connect method
+func (c *wrappedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedConn{conn: conn, logger: c.logger}, nil
+}
+
+// Driver implements driver.Connector Driver method
+func (c *wrappedConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+type wrappedConn struct {
+	conn   driver.Conn
+	logger *logger
+}
+
+// Prepare implements driver.Conn Prepare method
+func (c *wrappedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext implements driver.ConnPrepareContext PrepareContext method
+func (c *wrappedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var (
+		stmt driver.Stmt
+		err  error
+	)
+	if p, ok := c.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedStmt{stmt: stmt, query: query, logger: c.logger}, nil
+}
+
+// Close implements driver.Conn Close method
+func (c *wrappedConn) Close() error {
+	return c.conn.Close()
+}
+
+// Begin implements driver.Conn Begin method
+func (c *wrappedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx implements driver.ConnBeginTx BeginTx method
+func (c *wrappedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	var (
+		tx  driver.Tx
+		err error
+	)
+	if b, ok := c.conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.conn.Begin()
+	}
+	c.logger.log("begin", "", nil, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedTx{tx: tx, logger: c.logger}, nil
+}
+
+// ExecContext implements driver.ExecerContext ExecContext method
+func (c *wrappedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := e.ExecContext(ctx, query, unwrapArgs(args))
+	c.logger.log("exec", query, args, start, err)
+	return result, err
+}
+
+// QueryContext implements driver.QueryerContext QueryContext method
+func (c *wrappedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, query, unwrapArgs(args))
+	c.logger.log("query", query, args, start, err)
+	return rows, err
+}
+
+// Ping implements driver.Pinger Ping method
+func (c *wrappedConn) Ping(ctx context.Context) error {
+	if p, ok := c.conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// ResetSession implements driver.SessionResetter ResetSession method
+func (c *wrappedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+// CheckNamedValue implements driver.NamedValueChecker CheckNamedValue method
+func (c *wrappedConn) CheckNamedValue(v *driver.NamedValue) error {
+	return checkNamedValue(c.conn, v)
+}
+
+type wrappedTx struct {
+	tx     driver.Tx
+	logger *logger
+}
+
+// Commit implements driver.Tx Commit method
+func (tx *wrappedTx) Commit() error {
+	start := time.Now()
+	err := tx.tx.Commit()
+	tx.logger.log("commit", "", nil, start, err)
+	return err
+}
+
+// Rollback implements driver.Tx Rollback method
+func (tx *wrappedTx) Rollback() error {
+	start := time.Now()
+	err := tx.tx.Rollback()
+	tx.logger.log("rollback", "", nil, start, err)
+	return err
+}
+
+type wrappedStmt struct {
+	stmt   driver.Stmt
+	query  string
+	logger *logger
+}
+
+// Close implements driver.Stmt Close method
+func (s *wrappedStmt) Close() error {
+	return s.stmt.Close()
+}
+
+// NumInput implements driver.Stmt NumInput method
+func (s *wrappedStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+// Exec implements driver.Stmt Exec method
+func (s *wrappedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+// Query implements driver.Stmt Query method
+func (s *wrappedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+// ExecContext implements driver.StmtExecContext ExecContext method
+func (s *wrappedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var (
+		result driver.Result
+		err    error
+	)
+	if e, ok := s.stmt.(driver.StmtExecContext); ok {
+		result, err = e.ExecContext(ctx, unwrapArgs(args))
+	} else if values, verr := plainValues(args); verr != nil {
+		err = verr
+	} else {
+		result, err = s.stmt.Exec(values)
+	}
+	s.logger.log("exec", s.query, args, start, err)
+	return result, err
+}
+
+// QueryContext implements driver.StmtQueryContext QueryContext method
+func (s *wrappedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var (
+		rows driver.Rows
+		err  error
+	)
+	if q, ok := s.stmt.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, unwrapArgs(args))
+	} else if values, verr := plainValues(args); verr != nil {
+		err = verr
+	} else {
+		rows, err = s.stmt.Query(values)
+	}
+	s.logger.log("query", s.query, args, start, err)
+	return rows, err
+}
+
+// CheckNamedValue implements driver.NamedValueChecker CheckNamedValue method
+func (s *wrappedStmt) CheckNamedValue(v *driver.NamedValue) error {
+	return checkNamedValue(s.stmt, v)
+}
+
+// redactedArg wraps the converted value of an argument which implements
+// log.Redactor, so that the argument is still logged by LogRedact() after
+// converted. It's unwrapped by unwrapArgs before passed to the driver.
+type redactedArg struct {
+	value    driver.Value
+	redacted interface{}
+}
+
+// checkNamedValue checks v by checker if it implements
+// driver.NamedValueChecker, and wraps the converted value if v implements
+// log.Redactor. The value is converted by driver.DefaultParameterConverter
+// if checker skipped it.
+func checkNamedValue(checker interface{}, v *driver.NamedValue) error {
+	r, redacts := v.Value.(log.Redactor)
+	err := driver.ErrSkip
+	if c, ok := checker.(driver.NamedValueChecker); ok {
+		err = c.CheckNamedValue(v)
+	}
+	if !redacts || (err != nil && err != driver.ErrSkip) {
+		return err
+	}
+	if err == driver.ErrSkip {
+		value, err := driver.DefaultParameterConverter.ConvertValue(v.Value)
+		if err != nil {
+			return err
+		}
+		v.Value = value
+	}
+	v.Value = redactedArg{value: v.Value, redacted: r.LogRedact()}
+	return nil
+}
+
+// unwrapArgs returns args whose values wrapped by checkNamedValue are
+// unwrapped, args is returned as is if there are no such values
+func unwrapArgs(args []driver.NamedValue) []driver.NamedValue {
+	var unwrapped []driver.NamedValue
+	for i := range args {
+		if r, ok := args[i].Value.(redactedArg); ok {
+			if unwrapped == nil {
+				unwrapped = append([]driver.NamedValue(nil), args...)
+			}
+			unwrapped[i].Value = r.value
+		}
+	}
+	if unwrapped == nil {
+		return args
+	}
+	return unwrapped
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+func plainValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range unwrapArgs(args) {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("sqllog: driver does not support named argument %q", arg.Name)
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
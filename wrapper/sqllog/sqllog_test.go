@@ -0,0 +1,118 @@
+package sqllog_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gopherd/log"
+	"github.com/gopherd/log/wrapper/sqllog"
+)
+
+type testConnector struct{}
+
+func (testConnector) Connect(context.Context) (driver.Conn, error) { return testConn{}, nil }
+func (testConnector) Driver() driver.Driver                        { return nil }
+
+type testConn struct{}
+
+func (testConn) Prepare(query string) (driver.Stmt, error) { return testStmt{}, nil }
+func (testConn) Close() error                              { return nil }
+func (testConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+func (testConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if query == "bad" {
+		return nil, errors.New("syntax error")
+	}
+	for _, arg := range args {
+		if !driver.IsValue(arg.Value) {
+			return nil, fmt.Errorf("invalid argument %T", arg.Value)
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type testStmt struct{}
+
+func (testStmt) Close() error                                    { return nil }
+func (testStmt) NumInput() int                                   { return -1 }
+func (testStmt) Exec(args []driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (testStmt) Query(args []driver.Value) (driver.Rows, error)  { return testRows{}, nil }
+
+type testRows struct{}
+
+func (testRows) Columns() []string              { return nil }
+func (testRows) Close() error                   { return nil }
+func (testRows) Next(dest []driver.Value) error { return io.EOF }
+
+func TestWrap(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewLogger("")
+	logger.Start(log.WithOutput(&buf), log.WithSync(true), log.WithFlags(0), log.WithLevel(log.LevelDebug))
+	db := sql.OpenDB(sqllog.Wrap(testConnector{}, logger))
+	defer db.Close()
+
+	db.Exec("UPDATE users SET name=? WHERE id=?", "x", 1)
+	db.Exec("bad")
+	rows, err := db.Query("SELECT name FROM users WHERE id=?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	logger.Shutdown()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for i, prefix := range []string{
+		`[D] {query:"UPDATE users SET name=? WHERE id=?",args:["x","1"],elapsed:`,
+		`[D] {query:"bad",elapsed:`,
+		`[D] {query:"SELECT name FROM users WHERE id=?",args:["1"],elapsed:`,
+	} {
+		if i >= len(lines) || !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("line %d: want prefix %q, but got %q", i, prefix, buf.String())
+		}
+	}
+	if len(lines) != 3 || !strings.HasSuffix(lines[1], `,error:"syntax error"} exec`) || !strings.HasSuffix(lines[2], "} query") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+// password is logged as "***" but passed to the driver as the plain string
+type password string
+
+func (p password) Value() (driver.Value, error) { return string(p), nil }
+func (p password) LogRedact() interface{}       { return "***" }
+
+func TestRedactArgs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewLogger("")
+	logger.Start(log.WithOutput(&buf), log.WithSync(true), log.WithFlags(0), log.WithLevel(log.LevelDebug))
+	db := sql.OpenDB(sqllog.Wrap(testConnector{}, logger))
+	defer db.Close()
+
+	if _, err := db.Exec("UPDATE users SET password=? WHERE id=?", password("123456"), 1); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := db.Prepare("UPDATE users SET password=? WHERE id=?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stmt.Exec(password("123456"), 2); err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+	logger.Shutdown()
+
+	got := buf.String()
+	if strings.Contains(got, "123456") {
+		t.Errorf("password not redacted: %q", got)
+	}
+	if n := strings.Count(got, `args:["***",`); n != 2 {
+		t.Errorf("want 2 redacted entries, but got %d in %q", n, got)
+	}
+}
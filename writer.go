@@ -3,9 +3,12 @@ package log
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -13,6 +16,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,33 +26,105 @@ const (
 	GB = 1024 * MB
 )
 
-func parseSize(s string) (int64, error) {
-	n := len(s)
-	if n == 0 {
-		return 0, errors.New("invalid size")
-	}
-	u := s[n-1]
-	x := int64(1)
-	switch u {
-	case 'k', 'K':
-		x = KB
-	case 'm', 'M':
-		x = MB
-	case 'g', 'G':
-		x = GB
-	}
-	if x > 1 {
-		s = s[:n-1]
-	}
-	i, err := strconv.ParseInt(s, 10, 64)
+// ParseSize parses human-readable size such as 512, 64K, 64KB, 64KiB, 1.5M or 1G,
+// units are case-insensitive and all of them are powers of 1024.
+func ParseSize(s string) (int64, error) {
+	var (
+		num    = strings.ToUpper(strings.TrimSpace(s))
+		unit   = int64(1)
+		binary = strings.HasSuffix(num, "IB")
+	)
+	if binary {
+		num = num[:len(num)-2]
+	} else {
+		num = strings.TrimSuffix(num, "B")
+	}
+	if n := len(num); n > 0 {
+		switch num[n-1] {
+		case 'K':
+			unit = KB
+		case 'M':
+			unit = MB
+		case 'G':
+			unit = GB
+		}
+	}
+	invalid := errors.New("log: invalid size " + strconv.Quote(s))
+	if unit > 1 {
+		num = strings.TrimSpace(num[:len(num)-1])
+	} else if binary {
+		return 0, invalid
+	}
+	if i, err := strconv.ParseInt(num, 10, 64); err == nil {
+		// checks overflow before multiplying
+		if i > math.MaxInt64/unit || i < math.MinInt64/unit {
+			return 0, invalid
+		}
+		return i * unit, nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || unit == 1 {
+		return 0, invalid
+	}
+	// float64(math.MaxInt64) rounds up to 1<<63, which is out of range;
+	// NaN fails both comparisons
+	if f *= float64(unit); !(f >= math.MinInt64 && f < math.MaxInt64) {
+		return 0, invalid
+	}
+	return int64(f), nil
+}
+
+// ByteSize represents a number of bytes, it's marshaled to JSON as
+// human-readable string like "64M", and could be unmarshaled from either
+// such a string (see ParseSize) or a raw integer.
+type ByteSize int64
+
+// String returns the size with the largest unit which divides it exactly
+func (size ByteSize) String() string {
+	n := int64(size)
+	switch {
+	case n == 0:
+		return "0"
+	case n%GB == 0:
+		return strconv.FormatInt(n/GB, 10) + "G"
+	case n%MB == 0:
+		return strconv.FormatInt(n/MB, 10) + "M"
+	case n%KB == 0:
+		return strconv.FormatInt(n/KB, 10) + "K"
+	default:
+		return strconv.FormatInt(n, 10)
+	}
+}
+
+// MarshalJSON implements json.Marshaler MarshalJSON method
+func (size ByteSize) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(size.String())), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler UnmarshalJSON method
+func (size *ByteSize) UnmarshalJSON(data []byte) error {
+	var (
+		n   int64
+		err error
+	)
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err = json.Unmarshal(data, &s); err == nil {
+			n, err = ParseSize(s)
+		}
+	} else {
+		err = json.Unmarshal(data, &n)
+	}
 	if err != nil {
-		return 0, errors.New("invalid size")
+		return err
 	}
-	return i * x, nil
+	*size = ByteSize(n)
+	return nil
 }
 
 var (
-	errNilWriter = errors.New("log: nil writer")
+	errNilWriter  = errors.New("log: nil writer")
+	errFileClosed = errors.New("log: file closed")
 )
 
 // Writer represents a writer for logging
@@ -57,32 +133,196 @@ type Writer interface {
 	Close() error
 }
 
+// Entry represents a formatted log entry
+type Entry struct {
+	Level     Level  // level of the entry
+	Data      []byte // formatted data including the header
+	HeaderLen int    // length of header in Data
+}
+
+// BatchWriter is an optional interface which could be implemented by Writer.
+// The built in provider writes a batch of entries at once in async mode if the
+// writer implements it, otherwise it writes entries one by one.
+// NOTE: Data of entries is not available after WriteBatch returned.
+type BatchWriter interface {
+	Writer
+	WriteBatch(entries []Entry) error
+}
+
+// Record holds parts of a log entry for StructuredWriter
+type Record struct {
+	Level   Level
+	Time    time.Time
+	Caller  Caller // Line is 0 if the caller is not recorded by flags
+	Prefix  string
+	Fields  []Field  // fields put by Context decoded from RawFields, nil if RawFields is malformed
+	Message string   // as formatted, e.g. truncated, without the final line ending
+	Stack   []string // call stack of fatal entries like Context.Stack, not in Message
+
+	// RawFields is the encoded fields like {k:"v"} put by Context, or empty.
+	// It's malformed only if an invalid value is put by Context.RawJSON.
+	RawFields string
+}
+
+// StructuredWriter is an optional interface which could be implemented by
+// Writer. The built in provider calls WriteRecord instead of Write (and
+// WriteBatch) if the writer implements it, so that sinks get parts of the
+// entry without parsing the formatted data. Writers combined by WithWriters
+// or NewParallelMultiWriter are checked respectively.
+// NOTE: The record is not available after WriteRecord returned.
+type StructuredWriter interface {
+	Writer
+	WriteRecord(r *Record) error
+}
+
+// hasStructuredWriter reports whether writer or any inner writer of it
+// implements StructuredWriter
+func hasStructuredWriter(writer Writer) bool {
+	switch w := writer.(type) {
+	case StructuredWriter:
+		return true
+	case multiWriter:
+		return w.hasStructuredWriter()
+	case parallelMultiWriter:
+		return w.hasStructuredWriter()
+	}
+	return false
+}
+
+func (w multiWriter) hasStructuredWriter() bool {
+	for i := range w.writers {
+		if hasStructuredWriter(w.writers[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRecord writes the record of e to writer if it implements
+// StructuredWriter, otherwise writes the formatted data
+func writeRecord(writer Writer, e *entry) error {
+	switch w := writer.(type) {
+	case StructuredWriter:
+		return w.WriteRecord(&e.record)
+	case multiWriter:
+		var lastErr error
+		for i := range w.writers {
+			if err := writeRecord(w.writers[i], e); err != nil {
+				lastErr = err
+			}
+		}
+		return lastErr
+	case parallelMultiWriter:
+		return w.parallel(func(writer Writer) error {
+			return writeRecord(writer, e)
+		})
+	}
+	return write(writer, e.level, e.buf.Bytes(), e.header)
+}
+
+// HeaderAware is an optional interface which could be implemented by Writer.
+// If StripHeader returns true, the header is stripped from data passed to
+// Write (and WriteBatch) and headerLen is 0. It's useful for sinks which
+// provide their own metadata, e.g. syslog, journald.
+type HeaderAware interface {
+	Writer
+	StripHeader() bool
+}
+
+// StripHeader returns data without header, i.e. the prefix and message part
+func StripHeader(data []byte, headerLen int) []byte {
+	if headerLen <= 0 {
+		return data
+	}
+	if headerLen > len(data) {
+		return data[len(data):]
+	}
+	return data[headerLen:]
+}
+
+// stripsHeader reports whether the header should be stripped for writer
+func stripsHeader(writer Writer) bool {
+	hw, ok := writer.(HeaderAware)
+	return ok && hw.StripHeader()
+}
+
+// write writes data to writer, the header is stripped if writer requested
+func write(writer Writer, level Level, data []byte, headerLen int) error {
+	if stripsHeader(writer) {
+		return writer.Write(level, StripHeader(data, headerLen), 0)
+	}
+	return writer.Write(level, data, headerLen)
+}
+
 type WriterCreator func(source string) (Writer, error)
 
-var (
-	writerCreatorsMu sync.RWMutex
-	writerCreators   = make(map[string]WriterCreator)
-)
+// Registry holds writer creators by name, it's useful for libraries which
+// want to register their own writers without touching the package-level
+// registry used by Register and Open. The zero value is an empty registry
+// which doesn't fall back to the package-level registry.
+type Registry struct {
+	parent *Registry
+
+	mu       sync.RWMutex
+	creators map[string]WriterCreator
+}
+
+// NewRegistry creates an empty registry, Open of the registry falls back to
+// writers registered by the package-level Register (including the built in
+// writers) if the name is not registered in the registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		parent:   defaultRegistry,
+		creators: make(map[string]WriterCreator),
+	}
+}
+
+var defaultRegistry = &Registry{
+	creators: make(map[string]WriterCreator),
+}
 
 func init() {
 	Register("console", openConsole)
 	Register("file", openFile)
 	Register("multifile", openMultiFile)
+	Register("journald", openJournald)
+	Register("eventlog", openEventLog)
+	Register("null", openDiscard)
+	Register("discard", openDiscard)
+	Register("memory", openMemory)
 }
 
-func Register(name string, creator WriterCreator) {
+// Register registers the writer creator by name, it panics if the name
+// has been registered to the registry.
+func (r *Registry) Register(name string, creator WriterCreator) {
 	if creator == nil {
 		panic("log: Register creator is nil")
 	}
-	writerCreatorsMu.Lock()
-	defer writerCreatorsMu.Unlock()
-	if _, dup := writerCreators[name]; dup {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.creators[name]; dup {
 		panic("log: Register called twice for " + name)
 	}
-	writerCreators[name] = creator
+	if r.creators == nil {
+		r.creators = make(map[string]WriterCreator)
+	}
+	r.creators[name] = creator
 }
 
-func Open(url string) (Writer, error) {
+func (r *Registry) lookup(name string) (WriterCreator, bool) {
+	for ; r != nil; r = r.parent {
+		r.mu.RLock()
+		creator, ok := r.creators[name]
+		r.mu.RUnlock()
+		if ok {
+			return creator, true
+		}
+	}
+	return nil, false
+}
+
+// Open opens a writer by url formatted like `name[:source]`
+func (r *Registry) Open(url string) (Writer, error) {
 	var (
 		name   string
 		source string
@@ -98,15 +338,83 @@ func Open(url string) (Writer, error) {
 		return nil, errors.New("log: writer name is empty, url format: `name[:source]`")
 	}
 
-	writerCreatorsMu.RLock()
-	creator, ok := writerCreators[name]
-	writerCreatorsMu.RUnlock()
+	creator, ok := r.lookup(name)
 	if !ok {
 		return nil, fmt.Errorf("log: unknown writer %q (forgotten import?)", name)
 	}
 	return creator(source)
 }
 
+// OpenMulti opens writers by urls and combines them into one writer, e.g.
+//
+//	log.OpenMulti("file:/var/log/app.log?rotate=true", "console:stderr")
+//
+// Writers already opened are closed if any url fails to open.
+func (r *Registry) OpenMulti(urls ...string) (Writer, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("log: OpenMulti with no urls")
+	}
+	writers := make([]Writer, 0, len(urls))
+	for _, url := range urls {
+		w, err := r.Open(url)
+		if err != nil {
+			multiWriter{writers}.Close()
+			return nil, err
+		}
+		writers = append(writers, w)
+	}
+	if len(writers) == 1 {
+		return writers[0], nil
+	}
+	return multiWriter{writers}, nil
+}
+
+// Register registers the writer creator by name to the package-level registry
+func Register(name string, creator WriterCreator) {
+	defaultRegistry.Register(name, creator)
+}
+
+// Open opens a writer by url formatted like `name[:source]` from the
+// package-level registry
+func Open(url string) (Writer, error) {
+	return defaultRegistry.Open(url)
+}
+
+// OpenMulti opens writers by urls from the package-level registry, see
+// Registry.OpenMulti
+func OpenMulti(urls ...string) (Writer, error) {
+	return defaultRegistry.OpenMulti(urls...)
+}
+
+// Reopener is an optional interface which could be implemented by Writer to
+// reopen underlying files, it's useful for cooperating with external rotation
+// tools such as logrotate. Both file and multifile writers implement it.
+type Reopener interface {
+	Reopen() error
+}
+
+// Rotatable is an optional interface which could be implemented by Writer to
+// force rotating to a new log file. Both file and multifile writers implement it.
+type Rotatable interface {
+	Rotate() error
+}
+
+// HealthChecker is an optional interface which could be implemented by Writer
+// to report whether the sink is currently healthy, e.g. a network writer
+// returns an error while disconnected. It's useful for health endpoints, see
+// Logger.WritersHealth.
+type HealthChecker interface {
+	Healthy() error
+}
+
+// healthy returns the health of writer, nil if it doesn't implement HealthChecker
+func healthy(writer Writer) error {
+	if h, ok := writer.(HealthChecker); ok {
+		return h.Healthy()
+	}
+	return nil
+}
+
 // multiWriter merges multi-writers
 type multiWriter struct {
 	writers []Writer
@@ -116,7 +424,80 @@ type multiWriter struct {
 func (w multiWriter) Write(level Level, data []byte, headerLen int) error {
 	var lastErr error
 	for i := range w.writers {
-		if err := w.writers[i].Write(level, data, headerLen); err != nil {
+		if err := write(w.writers[i], level, data, headerLen); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// WriteBatch writes entries to all inner writers
+func (w multiWriter) WriteBatch(entries []Entry) error {
+	var lastErr error
+	for i := range w.writers {
+		if err := writeBatch(w.writers[i], entries); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// writeBatch writes entries to writer by WriteBatch if writer implements
+// BatchWriter, otherwise writes entries one by one.
+func writeBatch(writer Writer, entries []Entry) error {
+	if bw, ok := writer.(BatchWriter); ok {
+		if stripsHeader(writer) {
+			stripped := make([]Entry, len(entries))
+			for i := range entries {
+				stripped[i] = Entry{
+					Level: entries[i].Level,
+					Data:  StripHeader(entries[i].Data, entries[i].HeaderLen),
+				}
+			}
+			entries = stripped
+		}
+		return bw.WriteBatch(entries)
+	}
+	var lastErr error
+	for i := range entries {
+		if err := write(writer, entries[i].Level, entries[i].Data, entries[i].HeaderLen); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Reopen reopens all inner writers which implement Reopener
+func (w multiWriter) Reopen() error {
+	var lastErr error
+	for i := range w.writers {
+		if r, ok := w.writers[i].(Reopener); ok {
+			if err := r.Reopen(); err != nil {
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+// Rotate rotates all inner writers which implement Rotatable
+func (w multiWriter) Rotate() error {
+	var lastErr error
+	for i := range w.writers {
+		if r, ok := w.writers[i].(Rotatable); ok {
+			if err := r.Rotate(); err != nil {
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+// Healthy returns the last error of inner writers which implement HealthChecker
+func (w multiWriter) Healthy() error {
+	var lastErr error
+	for i := range w.writers {
+		if err := healthy(w.writers[i]); err != nil {
 			lastErr = err
 		}
 	}
@@ -134,9 +515,95 @@ func (w multiWriter) Close() error {
 	return lastErr
 }
 
+// parallelMultiWriter is a multiWriter which writes to inner writers concurrently
+type parallelMultiWriter struct {
+	multiWriter
+}
+
+// NewParallelMultiWriter creates a writer which writes every entry (or batch)
+// to the writers concurrently and waits until all of them finished, so that a
+// write takes as long as the slowest writer rather than the sum of all, and
+// each writer still sees entries in order. The slowest writer still delays
+// the next write of the others, wrap it by NewAsyncWriter to decouple it.
+// Unlike the sequential writer combined by WithWriters or OpenMulti, it costs
+// goroutines for every write, so it's worth only if some of the writers are
+// slow, e.g. network writers.
+func NewParallelMultiWriter(writers ...Writer) Writer {
+	for i, writer := range writers {
+		if writer == nil {
+			panic("log: NewParallelMultiWriter with a nil(" + strconv.Itoa(i+1) + "th) writer")
+		}
+	}
+	copied := make([]Writer, len(writers))
+	copy(copied, writers)
+	return parallelMultiWriter{multiWriter{copied}}
+}
+
+// parallel calls fn for every inner writer concurrently and returns the last error
+func (w parallelMultiWriter) parallel(fn func(Writer) error) error {
+	if len(w.writers) == 1 {
+		return fn(w.writers[0])
+	}
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(w.writers))
+	)
+	wg.Add(len(w.writers))
+	for i := range w.writers {
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = fn(w.writers[i])
+		}(i)
+	}
+	wg.Wait()
+	var lastErr error
+	for _, err := range errs {
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Write writes log to all inner writers concurrently
+func (w parallelMultiWriter) Write(level Level, data []byte, headerLen int) error {
+	return w.parallel(func(writer Writer) error {
+		return write(writer, level, data, headerLen)
+	})
+}
+
+// WriteBatch writes entries to all inner writers concurrently
+func (w parallelMultiWriter) WriteBatch(entries []Entry) error {
+	return w.parallel(func(writer Writer) error {
+		return writeBatch(writer, entries)
+	})
+}
+
+// Discard is a writer which discards all entries, it's also opened by
+// Open("null") or Open("discard")
+var Discard Writer = discard{}
+
+type discard struct{}
+
+func (discard) Write(Level, []byte, int) error { return nil }
+func (discard) Close() error                   { return nil }
+
+func openDiscard(source string) (Writer, error) {
+	if source != "" {
+		return nil, errors.New("log: invalid source for null: " + source)
+	}
+	return Discard, nil
+}
+
 // console is a writer that writes logs to console
 type console struct {
 	w io.Writer
+
+	colored int32 // accessed atomically, set with theme by setColorTheme
+
+	mu    sync.Mutex
+	theme map[Level]string // overrides Level.Color
+	buf   []byte           // used to concatenate entries in WriteBatch and colored entries
 }
 
 // newConsole creates a console writer
@@ -152,20 +619,237 @@ func openConsole(source string) (Writer, error) {
 		return newConsole(os.Stdout), nil
 	case "", "stderr":
 		return newConsole(os.Stderr), nil
+	case "split":
+		return NewSplitConsole(os.Stdout, os.Stderr), nil
 	default:
 		return nil, errors.New("log: invalid source for console: " + source)
 	}
 }
 
+// setColorTheme colorizes headers by theme, it's called by Start while the
+// writer may be in use by a running logger, so the lock is held.
+func (w *console) setColorTheme(theme map[Level]string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.theme = theme
+	atomic.StoreInt32(&w.colored, 1)
+}
+
+func (w *console) color(level Level) string {
+	if color, ok := w.theme[level]; ok {
+		return color
+	}
+	return level.Color()
+}
+
+// appendEntry appends data to buf, the header is colorized if colored. The
+// lock must be held.
+func (w *console) appendEntry(buf []byte, level Level, data []byte, headerLen int) []byte {
+	if atomic.LoadInt32(&w.colored) == 0 || headerLen <= 0 || headerLen > len(data) {
+		return append(buf, data...)
+	}
+	color := w.color(level)
+	if color == "" {
+		return append(buf, data...)
+	}
+	buf = append(buf, color...)
+	buf = append(buf, data[:headerLen]...)
+	buf = append(buf, ColorReset...)
+	return append(buf, data[headerLen:]...)
+}
+
 // Write implements Writer Write method
-func (w *console) Write(level Level, data []byte, _ int) error {
-	_, err := w.w.Write(data)
+func (w *console) Write(level Level, data []byte, headerLen int) error {
+	if atomic.LoadInt32(&w.colored) == 0 {
+		_, err := w.w.Write(data)
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = w.appendEntry(w.buf[:0], level, data, headerLen)
+	_, err := w.w.Write(w.buf)
+	if cap(w.buf) > 64*KB {
+		w.buf = nil
+	}
 	return err
 }
 
-// Close implements Writer Close method
-func (w *console) Close() error { return nil }
-
+// WriteBatch implements BatchWriter WriteBatch method, it concatenates
+// entries and writes them at once.
+func (w *console) WriteBatch(entries []Entry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = w.buf[:0]
+	for i := range entries {
+		w.buf = w.appendEntry(w.buf, entries[i].Level, entries[i].Data, entries[i].HeaderLen)
+	}
+	_, err := w.w.Write(w.buf)
+	if cap(w.buf) > 64*KB {
+		w.buf = nil
+	}
+	return err
+}
+
+// Close implements Writer Close method
+func (w *console) Close() error { return nil }
+
+// splitConsole is a writer that writes logs with level error or fatal to
+// stderr and the rest to stdout
+type splitConsole struct {
+	stdout *console
+	stderr *console
+}
+
+// NewSplitConsole creates a console writer which writes logs with level
+// error or fatal to stderr and the rest to stdout, it's also opened by
+// Open("console:split") with os.Stdout and os.Stderr.
+func NewSplitConsole(stdout, stderr io.Writer) Writer {
+	if stdout == nil || stderr == nil {
+		panic("log: NewSplitConsole with a nil writer")
+	}
+	return &splitConsole{
+		stdout: newConsole(stdout),
+		stderr: newConsole(stderr),
+	}
+}
+
+func (w *splitConsole) setColorTheme(theme map[Level]string) {
+	w.stdout.setColorTheme(theme)
+	w.stderr.setColorTheme(theme)
+}
+
+func (w *splitConsole) console(level Level) *console {
+	if level <= LevelError {
+		return w.stderr
+	}
+	return w.stdout
+}
+
+// Write implements Writer Write method
+func (w *splitConsole) Write(level Level, data []byte, headerLen int) error {
+	return w.console(level).Write(level, data, headerLen)
+}
+
+// WriteBatch implements BatchWriter WriteBatch method, consecutive entries
+// written to the same stream are concatenated and written at once.
+func (w *splitConsole) WriteBatch(entries []Entry) error {
+	var lastErr error
+	for len(entries) > 0 {
+		c := w.console(entries[0].Level)
+		n := 1
+		for n < len(entries) && w.console(entries[n].Level) == c {
+			n++
+		}
+		if err := c.WriteBatch(entries[:n]); err != nil {
+			lastErr = err
+		}
+		entries = entries[n:]
+	}
+	return lastErr
+}
+
+// Close implements Writer Close method
+func (w *splitConsole) Close() error { return nil }
+
+// stripANSIWriter is a writer which removes ANSI escape sequences from
+// entries before writing them to the underlying writer
+type stripANSIWriter struct {
+	writer Writer
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewStripANSIWriter creates a writer which removes ANSI escape sequences,
+// e.g. colors embedded in messages, from entries before writing them to w.
+// It's useful for file writers which share messages with colored consoles.
+func NewStripANSIWriter(w Writer) Writer {
+	if w == nil {
+		panic("log: NewStripANSIWriter with a nil writer")
+	}
+	return &stripANSIWriter{writer: w}
+}
+
+// Write implements Writer Write method
+func (w *stripANSIWriter) Write(level Level, data []byte, headerLen int) error {
+	if bytes.IndexByte(data, '\x1b') < 0 {
+		return write(w.writer, level, data, headerLen)
+	}
+	if headerLen < 0 || headerLen > len(data) {
+		headerLen = 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = stripANSI(w.buf[:0], data[:headerLen])
+	stripped := len(w.buf)
+	w.buf = stripANSI(w.buf, data[headerLen:])
+	err := write(w.writer, level, w.buf, stripped)
+	if cap(w.buf) > 64*KB {
+		w.buf = nil
+	}
+	return err
+}
+
+// Reopen implements Reopener Reopen method if the underlying writer implements it
+func (w *stripANSIWriter) Reopen() error {
+	if r, ok := w.writer.(Reopener); ok {
+		return r.Reopen()
+	}
+	return nil
+}
+
+// Rotate implements Rotatable Rotate method if the underlying writer implements it
+func (w *stripANSIWriter) Rotate() error {
+	if r, ok := w.writer.(Rotatable); ok {
+		return r.Rotate()
+	}
+	return nil
+}
+
+// Healthy implements HealthChecker Healthy method
+func (w *stripANSIWriter) Healthy() error { return healthy(w.writer) }
+
+// Close implements Writer Close method
+func (w *stripANSIWriter) Close() error { return w.writer.Close() }
+
+// stripANSI appends src to dst without ANSI escape sequences, i.e. CSI
+// sequences like "\x1b[31m", OSC sequences ended by BEL or ST, and other
+// two-byte escape sequences
+func stripANSI(dst, src []byte) []byte {
+	for i := 0; i < len(src); i++ {
+		if src[i] != '\x1b' {
+			dst = append(dst, src[i])
+			continue
+		}
+		if i+1 >= len(src) {
+			break
+		}
+		i++
+		switch src[i] {
+		case '[':
+			// parameter and intermediate bytes are followed by a final byte
+			for i+1 < len(src) && src[i+1] >= 0x20 && src[i+1] <= 0x3f {
+				i++
+			}
+			if i+1 < len(src) && src[i+1] >= 0x40 && src[i+1] <= 0x7e {
+				i++
+			}
+		case ']':
+			for i+1 < len(src) {
+				i++
+				if src[i] == '\a' {
+					break
+				}
+				if src[i] == '\x1b' && i+1 < len(src) && src[i+1] == '\\' {
+					i++
+					break
+				}
+			}
+		}
+	}
+	return dst
+}
+
 // File contains the basic writable file operations for logging
 type File interface {
 	io.WriteCloser
@@ -183,6 +867,15 @@ type FS interface {
 	MkdirAll(path string, perm os.FileMode) error                   // MkdirAll creates a directory
 }
 
+// ExtendedFS is an optional interface which could be implemented by FS, it's
+// required by features which list or rename log files. An FS which doesn't
+// implement it still works for basic logging.
+type ExtendedFS interface {
+	FS
+	ReadDir(name string) ([]os.FileInfo, error) // ReadDir reads the directory and returns entries sorted by filename
+	Rename(oldpath, newpath string) error       // Rename renames (moves) a file
+}
+
 // stdFS wraps the standard filesystem
 type stdFS struct{}
 
@@ -203,6 +896,12 @@ func (fs stdFS) Symlink(oldname, newname string) error { return os.Symlink(oldna
 // MkdirAll implements FS MkdirAll method
 func (fs stdFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
 
+// ReadDir implements ExtendedFS ReadDir method
+func (fs stdFS) ReadDir(name string) ([]os.FileInfo, error) { return ioutil.ReadDir(name) }
+
+// Rename implements ExtendedFS Rename method
+func (fs stdFS) Rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+
 // FileHeader represents header type of file
 type FileHeader int
 
@@ -210,8 +909,11 @@ type FileHeader int
 const (
 	NoHeader   FileHeader = 0 // no header in file
 	HTMLHeader FileHeader = 1 // append html header in file
+	JSONHeader FileHeader = 2 // append one-line json header with open time and build info instead of banner, regardless of NoBanner
 )
 
+var fileHeadersMu sync.RWMutex
+
 var fileHeaders = map[FileHeader]string{
 	HTMLHeader: `<br/><head>
 	<meta charset="UTF-8">
@@ -242,6 +944,125 @@ var fileHeaders = map[FileHeader]string{
 </head>`,
 }
 
+// RegisterFileHeader registers a custom header content which would be appended
+// to the beginning of each log file if FileOptions.Header is header.
+func RegisterFileHeader(header FileHeader, content string) {
+	if header == NoHeader || header == JSONHeader {
+		panic("log: RegisterFileHeader with a reserved header " + strconv.Itoa(int(header)))
+	}
+	fileHeadersMu.Lock()
+	defer fileHeadersMu.Unlock()
+	if _, dup := fileHeaders[header]; dup {
+		panic("log: RegisterFileHeader called twice for " + strconv.Itoa(int(header)))
+	}
+	fileHeaders[header] = content
+}
+
+func lookupFileHeader(header FileHeader) (string, bool) {
+	fileHeadersMu.RLock()
+	defer fileHeadersMu.RUnlock()
+	content, ok := fileHeaders[header]
+	return content, ok
+}
+
+// jsonFileHeader is the content of JSONHeader
+type jsonFileHeader struct {
+	OpenedAt  time.Time `json:"opened_at"`
+	Compiler  string    `json:"compiler"`
+	GoVersion string    `json:"go_version"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+}
+
+// FileFormat represents format of entries written to file
+type FileFormat int
+
+// FileFormat constants
+const (
+	TextFormat FileFormat = 0 // entries formatted by the provider
+	JSONFormat FileFormat = 1 // one JSON object per line (ndjson), see jsonRecord
+)
+
+// jsonRecord is the line written by JSONFormat file writers. Fields are
+// decoded by ParseFields and written as an object, they're kept as a string
+// of the Context encoding only if they're malformed, e.g. by RawJSON.
+type jsonRecord struct {
+	Time    time.Time       `json:"time"`
+	Level   string          `json:"level"`
+	File    string          `json:"file,omitempty"`
+	Line    int             `json:"line,omitempty"`
+	Func    string          `json:"func,omitempty"`
+	Prefix  string          `json:"prefix,omitempty"`
+	Fields  json.RawMessage `json:"fields,omitempty"`
+	Message string          `json:"msg"`
+	Stack   []string        `json:"stack,omitempty"`
+}
+
+// marshalRecord formats r as a line of JSONFormat files
+func marshalRecord(r *Record) ([]byte, error) {
+	line := jsonRecord{
+		Time:    r.Time,
+		Level:   r.Level.String(),
+		Prefix:  r.Prefix,
+		Message: strings.TrimSuffix(r.Message, "\n"),
+		Stack:   r.Stack,
+	}
+	if r.Caller.Line > 0 {
+		line.File = r.Caller.Filename
+		line.Line = r.Caller.Line
+		line.Func = r.Caller.Func()
+	}
+	if r.Fields != nil {
+		line.Fields = appendFieldsJSON(nil, r.Fields)
+	} else if r.RawFields != "" {
+		line.Fields = appendJSONString(nil, r.RawFields)
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// jsonFile is a file writer which writes records as ndjson. Entries written
+// by Write are still formatted by the provider, it happens only if the writer
+// is used by a custom provider.
+type jsonFile struct {
+	*file
+}
+
+// newFileWriter creates a file writer by options.Format
+func newFileWriter(options FileOptions) (Writer, error) {
+	f, err := newFile(options)
+	if err != nil {
+		return nil, err
+	}
+	return f.withFormat(), nil
+}
+
+func (w *file) withFormat() Writer {
+	if w.options.Format == JSONFormat {
+		return jsonFile{w}
+	}
+	return w
+}
+
+// WriteRecord implements StructuredWriter WriteRecord method
+func (w jsonFile) WriteRecord(r *Record) error {
+	data, err := marshalRecord(r)
+	if err != nil {
+		return err
+	}
+	return w.writeLine(data)
+}
+
+// writeLine writes a formatted line
+func (w *file) writeLine(data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.write(data)
+}
+
 // FileOptions represents options of file writer
 //
 // fullname of log file: $Filename.$date[.$rotateId]$Suffix
@@ -250,11 +1071,14 @@ type FileOptions struct {
 	Filename string     `json:"filename"` // log filename (default: <process name>)
 	Symdir   string     `json:"symdir"`   // symlinked directory (default: "")
 	Rotate   bool       `json:"rotate"`   // enable log rotate (default: false)
-	MaxSize  int64      `json:"maxsize"`  // max number bytes of log file (default: 64M)
+	MaxSize  ByteSize   `json:"maxsize"`  // max number bytes of log file (default: 64M)
 	Suffix   string     `json:"suffix"`   // filename suffix (default: .log)
 	Header   FileHeader `json:"header"`   // header type of file (default: NoHeader)
+	NoBanner bool       `json:"nobanner"` // disable the file-opened and build-info lines (default: false)
+	Format   FileFormat `json:"format"`   // format of entries (default: TextFormat)
 
-	FS FS `json:"-"` // custom filesystem (default: stdFS)
+	FS    FS    `json:"-"` // custom filesystem (default: stdFS)
+	Clock Clock `json:"-"` // provides current time for rotation (default: time.Now)
 }
 
 func (opt *FileOptions) setDefaults() {
@@ -279,6 +1103,9 @@ func (opt *FileOptions) setDefaults() {
 	if opt.FS == nil {
 		opt.FS = defaultFS
 	}
+	if opt.Clock == nil {
+		opt.Clock = stdClock{}
+	}
 }
 
 // file is a writer which writes logs to file
@@ -292,139 +1119,426 @@ type file struct {
 	mu     sync.Mutex
 	writer *bufio.Writer
 	file   File
-	quit   chan struct{}
+
+	// fixed is true if the file is provided by NewWriterFromFile, it's never
+	// rotated or reopened
+	fixed bool
+	// closed is true after Close, the file is never opened again
+	closed bool
 }
 
+// newFile creates a file writer which is flushed by the package-level flusher
 func newFile(options FileOptions) (*file, error) {
 	options.setDefaults()
 	w := &file{
 		options:  options,
 		rotateId: -1,
-		quit:     make(chan struct{}),
 	}
-	if err := w.rotate(time.Now()); err != nil {
+	if err := w.rotate(w.options.Clock.Now()); err != nil {
 		return nil, err
 	}
-	go func(f *file) {
-		ticker := time.NewTicker(time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				f.mu.Lock()
-				if f.written > 0 {
-					f.writer.Flush()
-					f.file.Sync()
-					f.written = 0
-				}
-				f.mu.Unlock()
-			case <-f.quit:
-				return
+	defaultFlusher.add(w)
+	return w, nil
+}
+
+// NewWriterFromFile creates a file writer which writes to the already opened
+// file f with buffering, e.g. a file inherited from the parent process or a
+// pipe. Dir, Filename, Symdir, Rotate, MaxSize and Suffix of options are
+// ignored, i.e. neither directories nor symlinks are created and the file is
+// never rotated. The banner and header are written unless disabled. The file
+// is closed by Close of the writer, it's left open if the banner or header
+// couldn't be written.
+func NewWriterFromFile(f File, options FileOptions) (Writer, error) {
+	if f == nil {
+		panic("log: NewWriterFromFile with a nil file")
+	}
+	options.setDefaults()
+	w := &file{
+		options: options,
+		fixed:   true,
+	}
+	w.createdAt = w.options.Clock.Now()
+	if err := w.use(f, w.createdAt); err != nil {
+		return nil, err
+	}
+	defaultFlusher.add(w)
+	return w.withFormat(), nil
+}
+
+// flush flushes buffered data to the file
+func (w *file) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.writer != nil && w.writer.Buffered() > 0 {
+		w.writer.Flush()
+		w.file.Sync()
+	}
+}
+
+// flusher flushes buffered data of files every second by a single goroutine,
+// the goroutine runs only while any file is added. All file writers are
+// flushed by defaultFlusher.
+type flusher struct {
+	mu    sync.Mutex
+	files map[*file]struct{}
+	quit  chan struct{}
+}
+
+func newFlusher() *flusher {
+	return &flusher{files: make(map[*file]struct{})}
+}
+
+// defaultFlusher flushes file writers
+var defaultFlusher = newFlusher()
+
+// add adds w to be flushed
+func (fl *flusher) add(w *file) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.files[w] = struct{}{}
+	if fl.quit == nil {
+		fl.quit = make(chan struct{})
+		go fl.run(fl.quit)
+	}
+}
+
+// remove removes w, it may be flushed once more by a flushing in progress
+func (fl *flusher) remove(w *file) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	delete(fl.files, w)
+	if len(fl.files) == 0 && fl.quit != nil {
+		close(fl.quit)
+		fl.quit = nil
+	}
+}
+
+func (fl *flusher) run(quit chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var files []*file
+	for {
+		select {
+		case <-ticker.C:
+			// files are flushed without holding the lock, so that adding or
+			// removing files isn't blocked by slow files
+			fl.mu.Lock()
+			for w := range fl.files {
+				files = append(files, w)
 			}
+			fl.mu.Unlock()
+			for i, w := range files {
+				w.flush()
+				files[i] = nil
+			}
+			files = files[:0]
+		case <-quit:
+			return
 		}
-	}(w)
-	return w, nil
+	}
 }
 
-func parseFileSource(opt *FileOptions, source string) (url.Values, error) {
+func parseFileSource(opt *FileOptions, source string, keys map[string]bool) (url.Values, error) {
+	var q url.Values
 	i := strings.Index(source, "?")
 	if i > 0 {
 		opt.Dir, opt.Filename = filepath.Split(source[:i])
+		var err error
+		q, err = url.ParseQuery(source[i+1:])
+		if err != nil {
+			return nil, errors.New("log: invalid source for file: " + source)
+		}
 	} else {
 		opt.Dir, opt.Filename = filepath.Split(source)
+		q = url.Values{}
 	}
 	opt.Dir = filepath.Clean(opt.Dir)
-	q, err := url.ParseQuery(source[i+1:])
-	if err != nil {
-		return nil, errors.New("log: invalid source for file: " + source)
+	if err := checkQueryKeys(q, keys, source); err != nil {
+		return nil, err
 	}
+	var err error
 	opt.Symdir = q.Get("symdir")
-	opt.MaxSize, _ = parseSize(q.Get("maxsize"))
-	opt.Rotate, _ = strconv.ParseBool(q.Get("rotate"))
+	if s := q.Get("maxsize"); s != "" {
+		size, err := ParseSize(s)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("log: invalid value %q for query %q", s, "maxsize")
+		}
+		opt.MaxSize = ByteSize(size)
+	}
+	if opt.Rotate, err = parseQueryBool(q, "rotate"); err != nil {
+		return nil, err
+	}
 	opt.Suffix = q.Get("suffix")
-	header, _ := strconv.Atoi(q.Get("header"))
-	opt.Header = FileHeader(header)
+	if opt.NoBanner, err = parseQueryBool(q, "nobanner"); err != nil {
+		return nil, err
+	}
+	if s := q.Get("header"); s != "" {
+		header, err := strconv.Atoi(s)
+		if err != nil || header < 0 {
+			return nil, fmt.Errorf("log: invalid value %q for query %q", s, "header")
+		}
+		opt.Header = FileHeader(header)
+	}
+	switch format := q.Get("format"); format {
+	case "", "text":
+		opt.Format = TextFormat
+	case "json":
+		opt.Format = JSONFormat
+	default:
+		return nil, fmt.Errorf("log: invalid value %q for query %q", format, "format")
+	}
 	opt.setDefaults()
 	return q, nil
 }
 
+// parseQueryBool parses boolean value of query key, it accepts values
+// accepted by strconv.ParseBool and yes/no/on/off. It returns false if
+// the key is absent.
+func parseQueryBool(q url.Values, key string) (bool, error) {
+	s := q.Get(key)
+	v, ok := parseBool(s)
+	if !ok {
+		return false, fmt.Errorf("log: invalid value %q for query %q", s, key)
+	}
+	return v, nil
+}
+
+func parseBool(s string) (v, ok bool) {
+	switch strings.ToLower(s) {
+	case "":
+		return false, true
+	case "yes", "on":
+		return true, true
+	case "no", "off":
+		return false, true
+	}
+	v, err := strconv.ParseBool(s)
+	return v, err == nil
+}
+
+// StrictQueryEnv is the environment variable which makes unknown query keys
+// of sources rejected by default if it's true, e.g. LOG_STRICT_QUERY=1.
+// Query strict of a source overrides it.
+const StrictQueryEnv = "LOG_STRICT_QUERY"
+
+// checkQueryKeys returns an error if q has a key not in keys while query
+// strict is true, or it's absent and the StrictQueryEnv variable is true
+func checkQueryKeys(q url.Values, keys map[string]bool, source string) error {
+	var strict bool
+	if _, ok := q["strict"]; ok {
+		var err error
+		if strict, err = parseQueryBool(q, "strict"); err != nil {
+			return err
+		}
+	} else {
+		s := os.Getenv(StrictQueryEnv)
+		var ok bool
+		if strict, ok = parseBool(s); !ok {
+			return fmt.Errorf("log: invalid value %q for environment variable %s", s, StrictQueryEnv)
+		}
+	}
+	if strict {
+		for key := range q {
+			if !keys[key] {
+				return fmt.Errorf("log: unknown query %q in source %q", key, source)
+			}
+		}
+	}
+	return nil
+}
+
+// fileQueryKeys holds the recognized query keys of file source
+var fileQueryKeys = map[string]bool{
+	"strict":   true,
+	"symdir":   true,
+	"maxsize":  true,
+	"rotate":   true,
+	"suffix":   true,
+	"nobanner": true,
+	"header":   true,
+	"format":   true,
+}
+
 // source format: path/to/file?k1=v1&...&kn=vn
+//
+// Invalid query values result in an error, and unknown query keys are
+// rejected too if query strict is true, see StrictQueryEnv.
 func openFile(source string) (Writer, error) {
 	var opt FileOptions
-	_, err := parseFileSource(&opt, source)
+	_, err := parseFileSource(&opt, source, fileQueryKeys)
 	if err != nil {
 		return nil, err
 	}
-	return newFile(opt)
+	return newFileWriter(opt)
 }
 
 // Write writes log to file
 func (w *file) Write(level Level, data []byte, _ int) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	return w.write(data)
+}
+
+// WriteBatch implements BatchWriter WriteBatch method
+func (w *file) WriteBatch(entries []Entry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i := range entries {
+		if err := w.write(entries[i].Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	if w.writer == nil {
-		return errNilWriter
+func (w *file) write(data []byte) error {
+	if w.closed {
+		return errFileClosed
+	}
+	if w.fixed {
+		n, err := w.writer.Write(data)
+		w.written += int64(n)
+		return err
 	}
-	now := time.Now()
+	now := w.options.Clock.Now()
 	if !isSameDay(now, w.createdAt) {
 		if err := w.rotate(now); err != nil {
 			return err
 		}
+	} else if w.writer == nil {
+		// the last creation failed, retry in append mode to keep written data
+		if err := w.open(now, true); err != nil {
+			return err
+		}
 	}
 	n, err := w.writer.Write(data)
 	w.written += int64(n)
-	if w.written >= w.options.MaxSize {
-		w.rotate(now)
+	if w.written >= int64(w.options.MaxSize) {
+		if rerr := w.rotate(now); err == nil {
+			err = rerr
+		}
 	}
 	return err
 }
 
+// clear flushes and closes current log file, the file is closed even if
+// flushing failed.
 func (w *file) clear() error {
+	var err error
 	if w.writer != nil {
-		if err := w.writer.Flush(); err != nil {
-			return err
-		}
-		if err := w.file.Sync(); err != nil {
-			return err
+		err = w.writer.Flush()
+		if serr := w.file.Sync(); err == nil {
+			err = serr
 		}
-		if err := w.file.Close(); err != nil {
-			return err
+		if cerr := w.file.Close(); err == nil {
+			err = cerr
 		}
+		w.file, w.writer = nil, nil
 	}
 	w.written = 0
-	return nil
+	return err
 }
 
 // Close closes current log file
 func (w *file) Close() error {
-	close(w.quit)
+	defaultFlusher.remove(w)
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	w.closed = true
 	return w.clear()
 }
 
+// Rotate implements Rotatable Rotate method
+func (w *file) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errFileClosed
+	}
+	if w.fixed {
+		return nil
+	}
+	return w.rotate(w.options.Clock.Now())
+}
+
+// Reopen implements Reopener Reopen method. It closes the current log file and
+// reopens it by the same name in append mode without banner and header. The
+// internal rotation is not affected, i.e. the file still be rotated by day or
+// size later.
+func (w *file) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errFileClosed
+	}
+	if w.fixed {
+		return nil
+	}
+	if err := w.clear(); err != nil {
+		return err
+	}
+	f, err := w.create(true)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.writer = bufio.NewWriterSize(w.file, 1<<14) // 16k
+	return nil
+}
+
+// rotate closes current log file and creates next one. If the creation
+// failed, the file writer is left closed and the creation is retried by the
+// next write.
 func (w *file) rotate(now time.Time) error {
-	w.clear()
+	err := w.clear()
 	if isSameDay(now, w.createdAt) {
 		w.rotateId = (w.rotateId + 1) % 1000
 	} else {
 		w.rotateId = 0
 	}
 	w.createdAt = now
+	if oerr := w.open(now, false); oerr != nil {
+		return oerr
+	}
+	return err
+}
 
-	var err error
-	w.file, err = w.create()
+// open creates current log file and writes the banner and header
+func (w *file) open(now time.Time, appending bool) error {
+	f, err := w.create(appending)
 	if err != nil {
 		return err
 	}
+	return w.use(f, now)
+}
 
+// use sets f as the current log file and writes the banner and header
+func (w *file) use(f File, now time.Time) error {
+	w.file = f
 	w.writer = bufio.NewWriterSize(w.file, 1<<14) // 16k
 	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "File opened at: %s.\n", now.Format("2006/01/02 15:04:05"))
-	fmt.Fprintf(&buf, "Built with %s %s for %s/%s.\n", runtime.Compiler, runtime.Version(), runtime.GOOS, runtime.GOARCH)
-	if header, ok := fileHeaders[w.options.Header]; ok {
-		fmt.Fprintln(&buf, header)
+	if w.options.Header == JSONHeader || (w.options.Format == JSONFormat && !w.options.NoBanner) {
+		// the header replaces the banner, so it's written regardless of
+		// NoBanner. Files of JSONFormat write the banner as the header to
+		// keep every line a JSON object, and other headers are dropped.
+		json.NewEncoder(&buf).Encode(jsonFileHeader{
+			OpenedAt:  now,
+			Compiler:  runtime.Compiler,
+			GoVersion: runtime.Version(),
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+		})
+	} else if w.options.Format != JSONFormat {
+		if !w.options.NoBanner {
+			fmt.Fprintf(&buf, "File opened at: %s.\n", now.Format("2006/01/02 15:04:05"))
+			fmt.Fprintf(&buf, "Built with %s %s for %s/%s.\n", runtime.Compiler, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		}
+		if header, ok := lookupFileHeader(w.options.Header); ok {
+			fmt.Fprintln(&buf, header)
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
 	}
 	n, err := w.file.Write(buf.Bytes())
 	w.written += int64(n)
@@ -433,7 +1547,9 @@ func (w *file) rotate(now time.Time) error {
 	return err
 }
 
-func (w *file) create() (File, error) {
+// create creates the current log file, the file is opened in append mode
+// if appending or options.Rotate is true, otherwise it's truncated.
+func (w *file) create(appending bool) (File, error) {
 	w.onceCreateLogDir.Do(w.createDir)
 
 	// make filename
@@ -456,7 +1572,7 @@ func (w *file) create() (File, error) {
 	if w.options.Symdir != "" {
 		fullname = filepath.Join(w.options.Dir, w.options.Symdir, name)
 	}
-	if w.options.Rotate {
+	if appending || w.options.Rotate {
 		f, err = w.options.FS.OpenFile(fullname, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 	} else {
 		f, err = w.options.FS.OpenFile(fullname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
@@ -492,6 +1608,11 @@ type MultiFileOptions struct {
 	InfoDir  string `json:"infodir"`  // info subdirectory (default: info)
 	DebugDir string `json:"debugdir"` // debug subdirectory (default: debug)
 	TraceDir string `json:"tracedir"` // trace subdirectory (default: trace)
+
+	// CombinedDir is the subdirectory of a combined file which all entries are
+	// written to as well (default: "", no combined file). Entries of a level
+	// whose subdirectory equals CombinedDir are written once.
+	CombinedDir string `json:"combineddir"`
 }
 
 func (opt *MultiFileOptions) setDefaults() {
@@ -518,8 +1639,12 @@ func (opt *MultiFileOptions) setDefaults() {
 
 type multiFile struct {
 	options MultiFileOptions
-	files   [numLevel]*file
 	group   map[string][]Level
+
+	mu       sync.Mutex
+	files    [numLevel]*file
+	combined *file
+	closed   bool // files are never opened after Close
 }
 
 func absPath(path string) string {
@@ -533,7 +1658,7 @@ func newMultiFile(options MultiFileOptions) *multiFile {
 	w.options = options
 	w.group = map[string][]Level{}
 	for level := LevelFatal; level <= LevelTrace; level++ {
-		dir := w.levelDir(level)
+		dir := absPath(w.optionsOfLevel(level).Dir)
 		if levels, ok := w.group[dir]; ok {
 			w.group[dir] = append(levels, level)
 		} else {
@@ -543,10 +1668,28 @@ func newMultiFile(options MultiFileOptions) *multiFile {
 	return w
 }
 
-// source format: path/to/file?k1=v1&...&kn=vn
+// multiFileQueryKeys holds the recognized query keys of multifile source
+var multiFileQueryKeys = map[string]bool{
+	"fataldir": true,
+	"errordir": true,
+	"warndir":  true,
+	"infodir":  true,
+	"debugdir": true,
+	"tracedir": true,
+
+	"combineddir": true,
+}
+
+func init() {
+	for key := range fileQueryKeys {
+		multiFileQueryKeys[key] = true
+	}
+}
+
+// source format: path/to/file?k1=v1&...&kn=vn, see openFile
 func openMultiFile(source string) (Writer, error) {
 	var opt MultiFileOptions
-	q, err := parseFileSource(&opt.FileOptions, source)
+	q, err := parseFileSource(&opt.FileOptions, source, multiFileQueryKeys)
 	if err != nil {
 		return nil, err
 	}
@@ -556,39 +1699,187 @@ func openMultiFile(source string) (Writer, error) {
 	opt.InfoDir = q.Get("infodir")
 	opt.DebugDir = q.Get("debugdir")
 	opt.TraceDir = q.Get("tracedir")
-	return newMultiFile(opt), nil
+	opt.CombinedDir = q.Get("combineddir")
+	return newMultiFileWriter(opt), nil
 }
 
 func (w *multiFile) Write(level Level, data []byte, headerLen int) error {
-	if w.files[level.index()] == nil {
+	return w.writeTo(level, func(f *file) error {
+		return f.Write(level, data, headerLen)
+	})
+}
+
+// writeTo calls write with the file of level and the combined file if it's
+// a different one, files are opened if necessary
+func (w *multiFile) writeTo(level Level, write func(f *file) error) error {
+	index := level.index()
+	if index < 0 || index >= len(w.files) {
+		return errUnrecognizedLevel
+	}
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return errFileClosed
+	}
+	if w.files[index] == nil {
 		if err := w.initForLevel(level); err != nil {
+			w.mu.Unlock()
+			return err
+		}
+	}
+	f := w.files[index]
+	if w.options.CombinedDir != "" && w.combined == nil {
+		if err := w.initCombined(); err != nil {
+			w.mu.Unlock()
 			return err
 		}
 	}
-	return w.files[level.index()].Write(level, data, headerLen)
+	combined := w.combined
+	w.mu.Unlock()
+	err := write(f)
+	if combined != nil && combined != f {
+		if cerr := write(combined); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// jsonMultiFile is a multiFile which writes records as ndjson like jsonFile
+type jsonMultiFile struct {
+	*multiFile
+}
+
+// newMultiFileWriter creates a multifile writer by options.Format
+func newMultiFileWriter(options MultiFileOptions) Writer {
+	w := newMultiFile(options)
+	if w.options.Format == JSONFormat {
+		return jsonMultiFile{w}
+	}
+	return w
+}
+
+// WriteRecord implements StructuredWriter WriteRecord method
+func (w jsonMultiFile) WriteRecord(r *Record) error {
+	data, err := marshalRecord(r)
+	if err != nil {
+		return err
+	}
+	return w.writeTo(r.Level, func(f *file) error {
+		return f.writeLine(data)
+	})
+}
+
+// initCombined creates the combined file
+func (w *multiFile) initCombined() error {
+	options := w.options.FileOptions
+	options.Dir = filepath.Join(options.Dir, w.options.CombinedDir)
+	f, err := newFile(options)
+	if err != nil {
+		return err
+	}
+	w.combined = f
+	return nil
+}
+
+// allFiles returns opened files without duplicates
+func (w *multiFile) allFiles() []*file {
+	var files []*file
+	for i, f := range w.files {
+		if f != nil && !w.isSharedFile(i) {
+			files = append(files, f)
+		}
+	}
+	if w.combined != nil {
+		shared := false
+		for _, f := range files {
+			if f == w.combined {
+				shared = true
+				break
+			}
+		}
+		if !shared {
+			files = append(files, w.combined)
+		}
+	}
+	return files
 }
 
 func (w *multiFile) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
 	var lastErr error
+	for _, f := range w.allFiles() {
+		if err := f.Close(); err != nil {
+			lastErr = err
+		}
+	}
 	for i := range w.files {
-		if w.files[i] != nil {
-			if err := w.files[i].Close(); err != nil {
-				lastErr = err
-			}
-			w.files[i] = nil
+		w.files[i] = nil
+	}
+	w.combined = nil
+	return lastErr
+}
+
+// Reopen implements Reopener Reopen method, it reopens all opened files
+func (w *multiFile) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var lastErr error
+	for _, f := range w.allFiles() {
+		if err := f.Reopen(); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Rotate implements Rotatable Rotate method, it rotates all opened files
+func (w *multiFile) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var lastErr error
+	for _, f := range w.allFiles() {
+		if err := f.Rotate(); err != nil {
+			lastErr = err
 		}
 	}
 	return lastErr
 }
 
+// isSharedFile reports whether files[i] is shared with a previous level
+func (w *multiFile) isSharedFile(i int) bool {
+	for j := 0; j < i; j++ {
+		if w.files[j] == w.files[i] {
+			return true
+		}
+	}
+	return false
+}
+
 func (w *multiFile) initForLevel(level Level) error {
 	index := level.index()
 	if index < 0 || index >= len(w.files) {
 		return errUnrecognizedLevel
 	}
-	f, err := newFile(w.optionsOfLevel(level))
-	if err != nil {
-		return err
+	var (
+		options = w.optionsOfLevel(level)
+		f       *file
+	)
+	if w.options.CombinedDir != "" && options.Dir == filepath.Join(w.options.Dir, w.options.CombinedDir) {
+		// the level shares the combined file
+		if w.combined == nil {
+			if err := w.initCombined(); err != nil {
+				return err
+			}
+		}
+		f = w.combined
+	} else {
+		var err error
+		if f, err = newFile(options); err != nil {
+			return err
+		}
 	}
 	w.files[index] = f
 	if levels, ok := w.group[absPath(f.options.Dir)]; ok {
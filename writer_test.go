@@ -0,0 +1,1074 @@
+package log_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopherd/log"
+)
+
+func TestMultiFileUnknownLevel(t *testing.T) {
+	w, err := log.Open("multifile:logs/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Write(log.Level(100), []byte("hello\n"), 0); err == nil {
+		t.Error("want an error for unknown level")
+	}
+}
+
+func TestMultiFileConcurrentWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multifile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w, err := log.Open("multifile:" + dir + "/app?nobanner=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	var wg sync.WaitGroup
+	for level := log.LevelFatal; level <= log.LevelTrace; level++ {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(level log.Level) {
+				defer wg.Done()
+				if err := w.Write(level, []byte("hello\n"), 0); err != nil {
+					t.Errorf("write error: %v", err)
+				}
+			}(level)
+		}
+	}
+	wg.Wait()
+}
+
+func TestFileUseAfterClose(t *testing.T) {
+	fs := newTestFS()
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithFile(log.FileOptions{Dir: "logs", Filename: "app", FS: fs}),
+		log.WithSync(true),
+	)
+	logger.Info().Print("hello")
+	logger.Shutdown()
+	if err := logger.Rotate(); err == nil {
+		t.Error("want an error for rotating a closed file")
+	}
+	if err := logger.Reopen(); err == nil {
+		t.Error("want an error for reopening a closed file")
+	}
+	for name, f := range fs.files {
+		if f.opened != 0 {
+			t.Errorf("file %s: want no handles opened, but got %d", name, f.opened)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "closed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, source := range []string{"file:" + dir + "/app", "multifile:" + dir + "/app"} {
+		w, err := log.Open(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(log.LevelInfo, []byte("hello\n"), 0)
+		w.Close()
+		infos, _ := ioutil.ReadDir(dir)
+		for _, info := range infos {
+			os.RemoveAll(filepath.Join(dir, info.Name()))
+		}
+		if err := w.Write(log.LevelInfo, []byte("hello\n"), 0); err == nil {
+			t.Errorf("%s: want an error for writing a closed file", source)
+		}
+		if infos, _ := ioutil.ReadDir(dir); len(infos) != 0 {
+			t.Errorf("%s: want the closed file not reopened, but got %d files", source, len(infos))
+		}
+	}
+}
+
+func TestColorThemeOfWriterInUse(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	w := log.NewSplitConsole(&stdout, &stderr)
+	running := log.NewLogger("")
+	running.Start(log.WithWriters(w), log.WithFlags(0), log.WithSync(true))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			running.Info().Print("info")
+		}
+	}()
+	// the writer is colorized by another logger while it's written
+	logger := log.NewLogger("")
+	logger.Start(log.WithWriters(w), log.WithColorTheme(nil))
+	<-done
+	running.Shutdown()
+	logger.Shutdown()
+	if n := strings.Count(stdout.String(), "info\n"); n != 100 {
+		t.Errorf("want 100 entries, but got %d", n)
+	}
+}
+
+// brokenFile fails to write
+type brokenFile struct{ closed bool }
+
+func (f *brokenFile) Write(p []byte) (int, error) { return 0, errors.New("broken pipe") }
+func (f *brokenFile) Close() error                { f.closed = true; return nil }
+func (f *brokenFile) Sync() error                 { return nil }
+
+func TestWriterFromFileError(t *testing.T) {
+	f := new(brokenFile)
+	if w, err := log.NewWriterFromFile(f, log.FileOptions{}); err == nil || w != nil {
+		t.Errorf("want an error for writing the banner, but got %v", err)
+	}
+	if f.closed {
+		t.Error("want the file left open")
+	}
+	w, err := log.NewWriterFromFile(f, log.FileOptions{NoBanner: true})
+	if err != nil {
+		t.Fatalf("want no error without banner, but got %v", err)
+	}
+	w.Close()
+}
+
+// testFS implements File interface
+type testFile struct {
+	content bytes.Buffer
+	opened  int // number of handles not closed yet
+}
+
+func (t *testFile) Write(p []byte) (int, error) { return t.content.Write(p) }
+func (t *testFile) Close() error                { t.opened--; return nil }
+func (t *testFile) Sync() error                 { return nil }
+
+// testFS implements FS interface
+type testFS struct {
+	files   map[string]*testFile
+	links   map[string]string
+	openErr error // returned by OpenFile if not nil
+}
+
+func newTestFS() *testFS {
+	return &testFS{
+		files: make(map[string]*testFile),
+		links: make(map[string]string),
+	}
+}
+
+// OpenFile implements FS OpenFile method
+func (fs testFS) OpenFile(name string, flag int, perm os.FileMode) (log.File, error) {
+	if fs.openErr != nil {
+		return nil, fs.openErr
+	}
+	f, ok := fs.files[name]
+	if ok {
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, os.ErrExist
+		}
+		if flag&os.O_TRUNC != 0 {
+			f.content.Reset()
+		}
+	} else if flag&os.O_CREATE != 0 {
+		f = &testFile{}
+		fs.files[name] = f
+	} else {
+		return nil, os.ErrNotExist
+	}
+	f.opened++
+	return f, nil
+}
+
+// Remove implements FS Remove method
+func (fs *testFS) Remove(name string) error {
+	if _, ok := fs.files[name]; !ok {
+		return os.ErrNotExist
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+// Symlink implements FS Symlink method
+func (fs testFS) Symlink(oldname, newname string) error {
+	fs.links[newname] = oldname
+	return nil
+}
+
+// MkdirAll implements FS MkdirAll method
+func (fs testFS) MkdirAll(path string, perm os.FileMode) error { return nil }
+
+// testFileInfo implements os.FileInfo interface
+type testFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi testFileInfo) Name() string       { return fi.name }
+func (fi testFileInfo) Size() int64        { return fi.size }
+func (fi testFileInfo) Mode() os.FileMode  { return 0666 }
+func (fi testFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi testFileInfo) IsDir() bool        { return false }
+func (fi testFileInfo) Sys() interface{}   { return nil }
+
+// ReadDir implements ExtendedFS ReadDir method
+func (fs testFS) ReadDir(name string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	for filename, f := range fs.files {
+		if filepath.Dir(filename) == filepath.Clean(name) {
+			infos = append(infos, testFileInfo{
+				name: filepath.Base(filename),
+				size: int64(f.content.Len()),
+			})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// Rename implements ExtendedFS Rename method
+func (fs testFS) Rename(oldpath, newpath string) error {
+	f, ok := fs.files[oldpath]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(fs.files, oldpath)
+	fs.files[newpath] = f
+	return nil
+}
+
+var _ log.ExtendedFS = (*testFS)(nil)
+
+// checkFiles checks contents of files in fs by slash-separated names
+func checkFiles(t *testing.T, fs *testFS, want map[string]string) {
+	t.Helper()
+	for name, content := range want {
+		f, ok := fs.files[filepath.FromSlash(name)]
+		if !ok {
+			t.Errorf("file %s not found", name)
+		} else if got := f.content.String(); got != content {
+			t.Errorf("file %s: want %q, but got %q", name, content, got)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	for _, symdir := range []string{"", "sym"} {
+		var (
+			fs    = newTestFS()
+			clock = &testClock{now: time.Date(2001, 2, 3, 23, 0, 0, 0, time.Local)}
+			dir   = filepath.Join("logs", symdir)
+		)
+		logger := log.NewLogger("")
+		logger.Start(
+			log.WithFile(log.FileOptions{
+				Dir:      "logs",
+				Filename: "app",
+				Symdir:   symdir,
+				MaxSize:  32,
+				NoBanner: true,
+				FS:       fs,
+				Clock:    clock,
+			}),
+			log.WithFlags(0),
+			log.WithSync(true),
+		)
+		// rotates by size
+		logger.Info().Print("0123456789")
+		logger.Info().Print("0123456789abcdef")
+		logger.Info().Print("size")
+		// rotates by day
+		clock.now = clock.now.Add(2 * time.Hour)
+		logger.Info().Print("day")
+		logger.Shutdown()
+
+		want := map[string]string{
+			filepath.Join(dir, "app.20010203.log"):     "[I] 0123456789\n[I] 0123456789abcdef\n",
+			filepath.Join(dir, "app.20010203.001.log"): "[I] size\n",
+			filepath.Join(dir, "app.20010204.log"):     "[I] day\n",
+		}
+		if len(fs.files) != len(want) {
+			t.Errorf("symdir %q: want %d files, but got %d", symdir, len(want), len(fs.files))
+		}
+		for name, content := range want {
+			f, ok := fs.files[name]
+			if !ok {
+				t.Errorf("symdir %q: file %s not found", symdir, name)
+			} else if got := f.content.String(); got != content {
+				t.Errorf("symdir %q: file %s: want %q, but got %q", symdir, name, content, got)
+			}
+		}
+		link := filepath.Join("logs", "app.log")
+		if symdir == "" {
+			if len(fs.links) != 0 {
+				t.Errorf("unexpected symlinks %v", fs.links)
+			}
+		} else if got, want := fs.links[link], filepath.Join(symdir, "app.20010204.log"); got != want {
+			t.Errorf("symlink %s: want %q, but got %q", link, want, got)
+		}
+	}
+}
+
+func TestClock(t *testing.T) {
+	var (
+		fs    = newTestFS()
+		clock = &testClock{now: time.Date(2001, 2, 3, 23, 59, 59, 0, time.Local)}
+	)
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithFile(log.FileOptions{
+			Dir:      "logs",
+			Filename: "app",
+			Rotate:   true,
+			NoBanner: true,
+			FS:       fs,
+			Clock:    clock,
+		}),
+		log.WithClock(clock),
+		log.WithFlags(log.Ltimestamp),
+		log.WithSync(true),
+	)
+	logger.Info().Print("before")
+	clock.now = clock.now.Add(time.Second)
+	logger.Info().Print("after")
+	logger.Shutdown()
+
+	checkFiles(t, fs, map[string]string{
+		"logs/app.20010203.log": "[I 2001/02/03 23:59:59] before\n",
+		"logs/app.20010204.log": "[I 2001/02/04 00:00:00] after\n",
+	})
+}
+
+func TestWriterFromFile(t *testing.T) {
+	var (
+		f     = new(testFile)
+		fs    = newTestFS()
+		clock = &testClock{now: time.Date(2001, 2, 3, 23, 0, 0, 0, time.Local)}
+	)
+	w, err := log.NewWriterFromFile(f, log.FileOptions{
+		Dir:      "logs",
+		Symdir:   "sym",
+		MaxSize:  8,
+		NoBanner: true,
+		FS:       fs,
+		Clock:    clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.NewLogger("")
+	logger.Start(log.WithWriters(w), log.WithFlags(0), log.WithSync(true))
+	logger.Info().Print("0123456789")
+	clock.now = clock.now.Add(2 * time.Hour)
+	logger.Info().Print("next day")
+	if err := logger.Rotate(); err != nil {
+		t.Errorf("rotate error: %v", err)
+	}
+	logger.Info().Print("rotated")
+	logger.Shutdown()
+	if want := "[I] 0123456789\n[I] next day\n[I] rotated\n"; f.content.String() != want {
+		t.Errorf("want %q, but got %q", want, f.content.String())
+	}
+	if len(fs.files) != 0 || len(fs.links) != 0 {
+		t.Errorf("unexpected files %v or links %v", fs.files, fs.links)
+	}
+	if err := w.Write(log.LevelInfo, []byte("closed\n"), 0); err == nil {
+		t.Error("want an error after closed")
+	}
+}
+
+func TestFileJSONFormat(t *testing.T) {
+	var (
+		fs      = newTestFS()
+		console bytes.Buffer
+		clock   = &testClock{now: time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)}
+	)
+	logger := log.NewLogger("app")
+	logger.Start(
+		log.WithFile(log.FileOptions{
+			Dir:      "logs",
+			Filename: "app",
+			Header:   log.HTMLHeader,
+			Format:   log.JSONFormat,
+			FS:       fs,
+			Clock:    clock,
+		}),
+		log.WithOutput(&console),
+		log.WithClock(clock),
+		log.WithFlags(0),
+		log.WithSync(true),
+	)
+	logger.Info().
+		String("k", "v").
+		Rune("r", '{').
+		String("s", `a,b}"c`).
+		Int("n", 1).
+		Dict("d", func(ctx *log.Context) { ctx.Bool("ok", true) }).
+		Print("hello")
+	logger.Warn().Print("world")
+	logger.Shutdown()
+
+	f, ok := fs.files[filepath.Join("logs", "app.20010203.log")]
+	if !ok {
+		t.Fatal("log file not found")
+	}
+	lines := strings.SplitAfter(f.content.String(), "\n")
+	if len(lines) != 4 || lines[3] != "" {
+		t.Fatalf("want 3 lines, but got %q", f.content.String())
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil || header["opened_at"] != "2001-02-03T04:05:06Z" {
+		t.Errorf("want a json banner, but got %q", lines[0])
+	}
+	want := `{"time":"2001-02-03T04:05:06Z","level":"INFO","prefix":"app","fields":{"k":"v","r":"{","s":"a,b}\"c","n":1,"d":{"ok":true}},"msg":"hello"}
+{"time":"2001-02-03T04:05:06Z","level":"WARN","prefix":"app","msg":"world"}
+`
+	if got := lines[1] + lines[2]; got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+	if want := "[I] (app) {k:\"v\",r:'{',s:\"a,b}\\\"c\",n:1,d:{ok:true}} hello\n[W] (app) world\n"; console.String() != want {
+		t.Errorf("console: want %q, but got %q", want, console.String())
+	}
+	if _, err := log.Open("file:app.log?format=xml"); err == nil {
+		t.Error("want an error for invalid format")
+	}
+}
+
+func TestFileJSONCaller(t *testing.T) {
+	fs := newTestFS()
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithFile(log.FileOptions{Dir: "logs", Filename: "app", Format: log.JSONFormat, FS: fs}),
+		log.WithFlags(log.Lshortfile),
+		log.WithSync(true),
+	)
+	_, _, line, _ := runtime.Caller(0)
+	logger.Info().Print("hello")
+	logger.Shutdown()
+
+	var lines []string
+	for _, f := range fs.files {
+		lines = append(lines, strings.SplitAfter(f.content.String(), "\n")...)
+	}
+	// a json banner, the line and the empty string after the last '\n'
+	if len(lines) != 3 {
+		t.Fatalf("want 2 lines, but got %q", lines)
+	}
+	var got struct {
+		File string
+		Line int
+		Func string
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.File != "writer_test.go" || got.Line != line+1 || got.Func != "github.com/gopherd/log_test.TestFileJSONCaller" {
+		t.Errorf("unexpected caller %+v in %q", got, lines[1])
+	}
+}
+
+func TestMultiFileJSONFormat(t *testing.T) {
+	fs := newTestFS()
+	clock := &testClock{now: time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)}
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithMultiFile(log.MultiFileOptions{
+			FileOptions: log.FileOptions{
+				Dir:      "logs",
+				Filename: "app",
+				NoBanner: true,
+				Format:   log.JSONFormat,
+				FS:       fs,
+				Clock:    clock,
+			},
+			CombinedDir: "all",
+		}),
+		log.WithClock(clock),
+		log.WithFlags(0),
+		log.WithSync(true),
+	)
+	logger.Info().Int("n", 1).Print("hello")
+	logger.Shutdown()
+
+	want := `{"time":"2001-02-03T04:05:06Z","level":"INFO","fields":{"n":1},"msg":"hello"}
+`
+	checkFiles(t, fs, map[string]string{
+		"logs/info/app.20010203.log": want,
+		"logs/all/app.20010203.log":  want,
+	})
+}
+
+func TestFileOpenError(t *testing.T) {
+	fs := newTestFS()
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithFile(log.FileOptions{
+			Dir:      "logs",
+			Filename: "app",
+			MaxSize:  32,
+			NoBanner: true,
+			FS:       fs,
+			Clock:    &testClock{now: time.Date(2001, 2, 3, 4, 5, 6, 0, time.Local)},
+		}),
+		log.WithFlags(0),
+		log.WithSync(true),
+	)
+	logger.Info().Print("0123456789abcdef")
+	fs.openErr = errors.New("disk full")
+	// rotates by size but fails to create next file
+	logger.Info().Print("0123456789abcdef")
+	logger.Info().Print("lost")
+	fs.openErr = nil
+	// retries to create next file
+	logger.Info().Print("retry")
+	logger.Shutdown()
+	checkFiles(t, fs, map[string]string{
+		"logs/app.20010203.log":     "[I] 0123456789abcdef\n[I] 0123456789abcdef\n",
+		"logs/app.20010203.001.log": "[I] retry\n",
+	})
+}
+
+func TestWithConsole(t *testing.T) {
+	for _, source := range []string{"", "stdout", "stderr", "split"} {
+		logger := log.NewLogger("")
+		if err := logger.Start(log.WithConsole(source), log.WithLevel(log.LevelFatal)); err != nil {
+			t.Errorf("source %q: unexpected error %v", source, err)
+		}
+		logger.Shutdown()
+	}
+	logger := log.NewLogger("")
+	if err := logger.Start(log.WithConsole("stdin")); err == nil {
+		logger.Shutdown()
+		t.Error("want an error for invalid source")
+	}
+}
+
+func TestFileNoBanner(t *testing.T) {
+	fs := newTestFS()
+	logger := log.NewLogger("")
+	err := logger.Start(
+		log.WithFile(log.FileOptions{Dir: "logs", Filename: "app", NoBanner: true, FS: fs}),
+		log.WithFlags(0),
+	)
+	if err != nil {
+		t.Fatalf("start logger error: %v", err)
+	}
+	logger.Info().Print("hello")
+	logger.Shutdown()
+	if len(fs.files) != 1 {
+		t.Fatalf("want 1 file, but got %d", len(fs.files))
+	}
+	for name, f := range fs.files {
+		if got, want := f.content.String(), "[I] hello\n"; got != want {
+			t.Errorf("file %s: want %q, but got %q", name, want, got)
+		}
+	}
+}
+
+const textHeader log.FileHeader = 100
+
+func init() {
+	log.RegisterFileHeader(textHeader, "# text header")
+}
+
+func TestFileHeader(t *testing.T) {
+	for _, tc := range []struct {
+		header log.FileHeader
+		check  func(firstLine string) error
+	}{
+		{log.JSONHeader, func(line string) error {
+			var header struct {
+				OpenedAt  time.Time `json:"opened_at"`
+				GoVersion string    `json:"go_version"`
+			}
+			if err := json.Unmarshal([]byte(line), &header); err != nil {
+				return err
+			}
+			if header.OpenedAt.IsZero() || header.GoVersion == "" {
+				return fmt.Errorf("incomplete json header %q", line)
+			}
+			return nil
+		}},
+		{textHeader, func(line string) error {
+			if line != "# text header" {
+				return fmt.Errorf("unexpected text header %q", line)
+			}
+			return nil
+		}},
+	} {
+		fs := newTestFS()
+		logger := log.NewLogger("")
+		logger.Start(log.WithFile(log.FileOptions{Filename: "app", Header: tc.header, NoBanner: true, FS: fs}))
+		logger.Shutdown()
+		for _, f := range fs.files {
+			line, err := f.content.ReadString('\n')
+			if err != nil {
+				t.Errorf("header %d: read first line error: %v", tc.header, err)
+			} else if err := tc.check(line[:len(line)-1]); err != nil {
+				t.Errorf("header %d: %v", tc.header, err)
+			}
+		}
+	}
+}
+
+// bodyWriter implements log.HeaderAware interface
+type bodyWriter struct {
+	bytes.Buffer
+}
+
+func (w *bodyWriter) Write(level log.Level, data []byte, headerLen int) error {
+	if headerLen != 0 {
+		return fmt.Errorf("unexpected header length %d", headerLen)
+	}
+	w.Buffer.Write(data)
+	return nil
+}
+
+func (w *bodyWriter) Close() error      { return nil }
+func (w *bodyWriter) StripHeader() bool { return true }
+
+func TestStripHeader(t *testing.T) {
+	writer := new(bodyWriter)
+	var buf bytes.Buffer
+	logger := log.NewLogger("testing")
+	logger.Start(log.WithWriters(writer), log.WithOutput(&buf), log.WithFlags(log.Ltimestamp), log.WithSync(true))
+	logger.Info().Int("x", 1).Print("hello")
+	logger.Shutdown()
+	if got, want := writer.String(), "(testing) {x:1} hello\n"; got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("[I ")) {
+		t.Errorf("want header for console, but got %q", buf.String())
+	}
+	if got := string(log.StripHeader([]byte("[I] body"), 4)); got != "body" {
+		t.Errorf("want %q, but got %q", "body", got)
+	}
+}
+
+func TestFileReopen(t *testing.T) {
+	fs := newTestFS()
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithFile(log.FileOptions{Dir: "logs", Filename: "app", NoBanner: true, FS: fs}),
+		log.WithFlags(0),
+		log.WithSync(true),
+	)
+	logger.Info().Print("before")
+	infos, _ := fs.ReadDir("logs")
+	if len(infos) != 1 {
+		t.Fatalf("want 1 file, but got %d", len(infos))
+	}
+	name := filepath.Join("logs", infos[0].Name())
+	fs.Rename(name, name+".1")
+	if err := logger.Reopen(); err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	logger.Info().Print("after")
+	logger.Shutdown()
+	checkFiles(t, fs, map[string]string{
+		name + ".1": "[I] before\n",
+		name:        "[I] after\n",
+	})
+}
+
+func TestFileRotate(t *testing.T) {
+	fs := newTestFS()
+	clock := &testClock{now: time.Date(2001, 2, 3, 23, 59, 59, 0, time.Local)}
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithFile(log.FileOptions{Dir: "logs", Filename: "app", NoBanner: true, FS: fs, Clock: clock}),
+		log.WithFlags(0),
+		log.WithSync(true),
+	)
+	logger.Info().Print("first")
+	if err := logger.Rotate(); err != nil {
+		t.Fatalf("rotate error: %v", err)
+	}
+	logger.Info().Print("second")
+	logger.Shutdown()
+	checkFiles(t, fs, map[string]string{
+		"logs/app.20010203.log":     "[I] first\n",
+		"logs/app.20010203.001.log": "[I] second\n",
+	})
+}
+
+func TestSplitConsole(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	logger := log.NewLogger("")
+	logger.Start(log.WithWriters(log.NewSplitConsole(&stdout, &stderr)), log.WithFlags(0), log.WithLevel(log.LevelDebug))
+	logger.Info().Print("info")
+	logger.Error().Print("error")
+	logger.Warn().Print("warn")
+	logger.Debug().Print("debug")
+	logger.Shutdown()
+	if want := "[I] info\n[W] warn\n[D] debug\n"; stdout.String() != want {
+		t.Errorf("stdout: want %q, but got %q", want, stdout.String())
+	}
+	if want := "[E] error\n"; stderr.String() != want {
+		t.Errorf("stderr: want %q, but got %q", want, stderr.String())
+	}
+	if _, err := log.Open("console:split"); err != nil {
+		t.Errorf("open console:split: %v", err)
+	}
+}
+
+func TestDiscardWriter(t *testing.T) {
+	for _, url := range []string{"null", "discard"} {
+		w, err := log.Open(url)
+		if err != nil {
+			t.Fatalf("open %s: %v", url, err)
+		}
+		if err := w.Write(log.LevelInfo, []byte("hello\n"), 0); err != nil {
+			t.Errorf("%s: unexpected write error %v", url, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Errorf("%s: unexpected close error %v", url, err)
+		}
+	}
+	if _, err := log.Open("null:x"); err == nil {
+		t.Error("want an error for invalid source")
+	}
+	data := []byte("hello\n")
+	if n := testing.AllocsPerRun(100, func() { log.Discard.Write(log.LevelInfo, data, 0) }); n != 0 {
+		t.Errorf("want no allocations, but got %v", n)
+	}
+}
+
+func TestColorTheme(t *testing.T) {
+	if got := log.LevelError.Color(); got != "\x1b[31m" {
+		t.Errorf("want red for level error, but got %q", got)
+	}
+	log.RegisterLevelColor(log.Level(7), "\x1b[34m")
+	if got := log.Level(7).Color(); got != "\x1b[34m" {
+		t.Errorf("want registered color for custom level, but got %q", got)
+	}
+	for _, synced := range []bool{true, false} {
+		var buf bytes.Buffer
+		logger := log.NewLogger("")
+		logger.Start(
+			log.WithOutput(&buf),
+			log.WithSync(synced),
+			log.WithFlags(0),
+			log.WithColorTheme(map[log.Level]string{log.LevelWarn: "<w>"}),
+		)
+		logger.Info().Print("info")
+		logger.Warn().Print("warn")
+		logger.Shutdown()
+		want := "\x1b[32m[I] " + log.ColorReset + "info\n" +
+			"<w>[W] " + log.ColorReset + "warn\n"
+		if got := buf.String(); got != want {
+			t.Errorf("sync %v: want %q, but got %q", synced, want, got)
+		}
+	}
+}
+
+// closingWriter counts closed writers opened by url "closing"
+type closingWriter struct {
+	testingLogWriter
+}
+
+var closedWriters int
+
+func (w *closingWriter) Close() error {
+	closedWriters++
+	return nil
+}
+
+func init() {
+	log.Register("closing", func(source string) (log.Writer, error) {
+		return new(closingWriter), nil
+	})
+}
+
+func TestOpenMulti(t *testing.T) {
+	closedWriters = 0
+	if _, err := log.OpenMulti("closing", "closing", "console:unknown"); err == nil {
+		t.Fatal("want an error for invalid url")
+	}
+	if closedWriters != 2 {
+		t.Fatalf("want 2 writers closed, but got %d", closedWriters)
+	}
+	w, err := log.OpenMulti("closing", "closing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if closedWriters != 4 {
+		t.Fatalf("want 4 writers closed, but got %d", closedWriters)
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, tc := range []struct {
+		url string
+		ok  bool
+	}{
+		{"file:" + dir + "/app?rotate=yes&maxsize=1M&nobanner=off", true},
+		{"file:" + dir + "/app?maxsiz=1M", true},
+		{"multifile:" + dir + "/app?strict=on&infodir=info", true},
+		{"file:" + dir + "/app?maxsize=64X", false},
+		{"file:" + dir + "/app?maxsize=-1", false},
+		{"file:" + dir + "/app?rotate=maybe", false},
+		{"file:" + dir + "/app?header=text", false},
+		{"file:" + dir + "/app?strict=true&maxsiz=1M", false},
+		{"multifile:" + dir + "/app?strict=on&infodri=info", false},
+	} {
+		w, err := log.Open(tc.url)
+		if tc.ok != (err == nil) {
+			t.Errorf("%s: unexpected error %v", tc.url, err)
+		}
+		if w != nil {
+			w.Close()
+		}
+	}
+
+	os.Setenv(log.StrictQueryEnv, "on")
+	defer os.Unsetenv(log.StrictQueryEnv)
+	for _, tc := range []struct {
+		url string
+		ok  bool
+	}{
+		{"file:" + dir + "/app?maxsize=1M", true},
+		{"file:" + dir + "/app?maxsiz=1M", false},
+		{"file:" + dir + "/app?strict=false&maxsiz=1M", true},
+		{"multifile:" + dir + "/app?infodri=info", false},
+	} {
+		w, err := log.Open(tc.url)
+		if tc.ok != (err == nil) {
+			t.Errorf("%s with %s: unexpected error %v", tc.url, log.StrictQueryEnv, err)
+		}
+		if w != nil {
+			w.Close()
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		size int64
+		ok   bool
+	}{
+		{"512", 512, true},
+		{"512B", 512, true},
+		{"64k", 64 * log.KB, true},
+		{"64KB", 64 * log.KB, true},
+		{"64 KiB", 64 * log.KB, true},
+		{"1.5M", 3 * log.MB / 2, true},
+		{"1G", log.GB, true},
+		{"", 0, false},
+		{"64X", 0, false},
+		{"1.5", 0, false},
+		{"64iB", 0, false},
+		{"8589934591G", 8589934591 * log.GB, true},
+		{"8589934592G", 0, false},
+		{"99999999999G", 0, false},
+		{"-99999999999G", 0, false},
+		{"9999999999.5G", 0, false},
+		{"NaNG", 0, false},
+	} {
+		size, err := log.ParseSize(tc.s)
+		if tc.ok != (err == nil) || size != tc.size {
+			t.Errorf("%q: want %d, but got %d (error %v)", tc.s, tc.size, size, err)
+		}
+	}
+
+	var opt log.FileOptions
+	if err := json.Unmarshal([]byte(`{"maxsize":"64MiB"}`), &opt); err != nil || opt.MaxSize != 64*log.MB {
+		t.Errorf("unmarshal string maxsize: %v, %d", err, opt.MaxSize)
+	}
+	if err := json.Unmarshal([]byte(`{"maxsize":1024}`), &opt); err != nil || opt.MaxSize != log.KB {
+		t.Errorf("unmarshal integer maxsize: %v, %d", err, opt.MaxSize)
+	}
+	if data, err := json.Marshal(log.ByteSize(3 * log.MB)); err != nil || string(data) != `"3M"` {
+		t.Errorf("marshal size: %v, %s", err, data)
+	}
+}
+
+func TestStripANSIWriter(t *testing.T) {
+	var (
+		colored = new(bytes.Buffer)
+		plain   = new(testingLogWriter)
+		logger  = log.NewLogger("")
+	)
+	logger.Start(
+		log.WithWriters(log.NewStripANSIWriter(plain)),
+		log.WithOutput(colored),
+		log.WithColorTheme(nil),
+		log.WithSync(true),
+		log.WithFlags(0),
+	)
+	logger.Info().Int("id", 1).Print("\x1b[1;31mred\x1b[0m \x1b]0;title\x07done\x1b[K")
+	logger.Info().Print("plain")
+	logger.Shutdown()
+	want := `[INFO] {id:1} red done
+[INFO] plain
+`
+	if got := plain.buf.String(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+	if got := colored.String(); !strings.Contains(got, "\x1b[1;31mred") {
+		t.Errorf("console entries should keep escape sequences: %q", got)
+	}
+}
+
+func TestParallelMultiWriter(t *testing.T) {
+	var (
+		slow = &gatedWriter{started: make(chan struct{}), gate: make(chan struct{})}
+		fast = &gatedWriter{started: make(chan struct{}), gate: make(chan struct{})}
+		w    = log.NewParallelMultiWriter(slow, fast)
+		done = make(chan error)
+	)
+	close(fast.gate)
+	go func() {
+		done <- w.Write(log.LevelInfo, []byte("first\n"), 0)
+	}()
+	// the fast writer is not delayed by the slow one
+	<-slow.started
+	<-fast.started
+	close(slow.gate)
+	if err := <-done; err != nil {
+		t.Errorf("write error: %v", err)
+	}
+	w.Write(log.LevelInfo, []byte("second\n"), 0)
+	w.Close()
+	for _, buf := range []*bytes.Buffer{&slow.buf, &fast.buf} {
+		if want := "[INFO] first\n[INFO] second\n"; buf.String() != want {
+			t.Errorf("want %q, but got %q", want, buf.String())
+		}
+	}
+}
+
+// recordWriter keeps records written by WriteRecord
+type recordWriter struct {
+	testingLogWriter
+	records []log.Record
+}
+
+func (w *recordWriter) WriteRecord(r *log.Record) error {
+	w.records = append(w.records, *r)
+	return nil
+}
+
+func TestStructuredWriter(t *testing.T) {
+	for _, synced := range []bool{true, false} {
+		var (
+			structured = new(recordWriter)
+			plain      = new(testingLogWriter)
+			clock      = &testClock{now: time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)}
+			logger     = log.NewLogger("app")
+		)
+		logger.Start(
+			log.WithWriters(structured, plain),
+			log.WithSync(synced),
+			log.WithClock(clock),
+			log.WithFlags(log.Lshortfile),
+		)
+		logger.Info().String("k", "v").Print("with fields")
+		logger.Warn().Print("no fields")
+		logger.Shutdown()
+
+		if len(structured.records) != 2 {
+			t.Fatalf("sync %v: want 2 records, but got %d", synced, len(structured.records))
+		}
+		if structured.buf.Len() > 0 {
+			t.Errorf("sync %v: want no formatted data, but got %q", synced, structured.buf.String())
+		}
+		r := structured.records[0]
+		if r.Level != log.LevelInfo || !r.Time.Equal(clock.now) || r.Prefix != "app" ||
+			r.RawFields != `{k:"v"}` || !reflect.DeepEqual(r.Fields, []log.Field{{Key: "k", Value: "v"}}) ||
+			r.Message != "with fields" ||
+			r.Caller.Filename != "writer_test.go" || r.Caller.Line <= 0 {
+			t.Errorf("sync %v: unexpected record %+v", synced, r)
+		}
+		r = structured.records[1]
+		if r.Level != log.LevelWarn || r.RawFields != "" || r.Fields != nil || r.Message != "no fields" {
+			t.Errorf("sync %v: unexpected record %+v", synced, r)
+		}
+		if want := "[INFO] (app) {k:\"v\"} with fields\n[WARN] (app) no fields\n"; plain.buf.String() != want {
+			t.Errorf("sync %v: want %q, but got %q", synced, want, plain.buf.String())
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	var opened string
+	registry := log.NewRegistry()
+	registry.Register("file", func(source string) (log.Writer, error) {
+		opened = source
+		return new(testingLogWriter), nil
+	})
+	if _, err := registry.Open("file:app.log"); err != nil || opened != "app.log" {
+		t.Errorf("open file from registry: %v, %q", err, opened)
+	}
+	// falls back to the package-level registry
+	if _, err := registry.Open("console:stderr"); err != nil {
+		t.Errorf("open console from registry: %v", err)
+	}
+	if _, err := log.Open("unknown"); err == nil {
+		t.Error("want an error for unknown writer")
+	}
+
+	var zero log.Registry
+	if _, err := zero.Open("console:stderr"); err == nil {
+		t.Error("want an error for console from the zero registry")
+	}
+	zero.Register("test", func(source string) (log.Writer, error) {
+		return new(testingLogWriter), nil
+	})
+	if _, err := zero.Open("test"); err != nil {
+		t.Errorf("open test from the zero registry: %v", err)
+	}
+}
+
+func TestMultiFileCombined(t *testing.T) {
+	fs := newTestFS()
+	logger := log.NewLogger("")
+	logger.Start(
+		log.WithMultiFile(log.MultiFileOptions{
+			FileOptions: log.FileOptions{
+				Dir:      "logs",
+				Filename: "app",
+				NoBanner: true,
+				FS:       fs,
+				Clock:    &testClock{now: time.Date(2001, 2, 3, 4, 5, 6, 0, time.Local)},
+			},
+			InfoDir:     "all",
+			CombinedDir: "all",
+		}),
+		log.WithFlags(0),
+		log.WithSync(true),
+	)
+	logger.Info().Print("info")
+	logger.Error().Print("error")
+	logger.Warn().Print("warn")
+	logger.Shutdown()
+	want := map[string]string{
+		"logs/all/app.20010203.log":   "[I] info\n[E] error\n[W] warn\n",
+		"logs/error/app.20010203.log": "[E] error\n",
+		"logs/warn/app.20010203.log":  "[W] warn\n",
+	}
+	if len(fs.files) != len(want) {
+		t.Errorf("want %d files, but got %d", len(want), len(fs.files))
+	}
+	checkFiles(t, fs, want)
+}